@@ -0,0 +1,328 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theforgeinitiative/integrations/db"
+	"github.com/theforgeinitiative/integrations/reconcile"
+)
+
+// keys look like tfi_<id>_<secret>
+const apiKeyPrefix = "tfi_"
+
+// how long a key lookup is trusted before checking Firestore again. This
+// bounds how long a key revoked on another instance keeps working.
+const defaultAPIKeyCacheTTL = 5 * time.Second
+
+// last used timestamps only need to be roughly right, so don't write every call
+const apiKeyTouchInterval = time.Minute
+
+var errInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeyStore is satisfied by db.Client
+type APIKeyStore interface {
+	CreateAPIKey(key db.APIKey) error
+	GetAPIKey(id string) (db.APIKey, error)
+	ListAPIKeys() ([]db.APIKey, error)
+	RevokeAPIKey(id string) error
+	TouchAPIKey(id string, used time.Time) error
+}
+
+type cachedAPIKey struct {
+	key     db.APIKey
+	found   bool
+	fetched time.Time
+}
+
+// APIKeys authenticates machine callers with keys kept in Firestore
+type APIKeys struct {
+	Store    APIKeyStore
+	CacheTTL time.Duration
+
+	mu      sync.Mutex
+	cache   map[string]cachedAPIKey
+	touched map[string]time.Time
+	// last used writes still in flight
+	touching sync.WaitGroup
+}
+
+func NewAPIKeys(store APIKeyStore) *APIKeys {
+	return &APIKeys{
+		Store:    store,
+		CacheTTL: defaultAPIKeyCacheTTL,
+		cache:    make(map[string]cachedAPIKey),
+		touched:  make(map[string]time.Time),
+	}
+}
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+func parseAPIKey(token string) (id, secret string, ok bool) {
+	id, secret, ok = strings.Cut(strings.TrimPrefix(token, apiKeyPrefix), "_")
+	return id, secret, ok && len(id) > 0 && len(secret) > 0
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKey returns the full key to hand out once, and the record to store
+func newAPIKey(name string, scopes []string, expires time.Time, createdBy string) (string, db.APIKey, error) {
+	idBytes := make([]byte, 8)
+	secretBytes := make([]byte, 32)
+	_, err := rand.Read(idBytes)
+	if err == nil {
+		_, err = rand.Read(secretBytes)
+	}
+	if err != nil {
+		return "", db.APIKey{}, fmt.Errorf("failed to generate api key: %w", err)
+	}
+	id := hex.EncodeToString(idBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	return apiKeyPrefix + id + "_" + secret, db.APIKey{
+		ID:        id,
+		Name:      name,
+		Hash:      hashSecret(secret),
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+		ExpiresAt: expires,
+	}, nil
+}
+
+// Authenticate returns the stored key if token is valid, unrevoked and
+// unexpired. Failing to record when it was used is only logged.
+func (k *APIKeys) Authenticate(token string, logger reconcile.Logger) (db.APIKey, error) {
+	id, secret, ok := parseAPIKey(token)
+	if !ok {
+		return db.APIKey{}, errInvalidAPIKey
+	}
+	key, found, err := k.lookup(id)
+	if err != nil {
+		return db.APIKey{}, err
+	}
+	if !found || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashSecret(secret))) != 1 {
+		return db.APIKey{}, errInvalidAPIKey
+	}
+	if key.Revoked {
+		return db.APIKey{}, fmt.Errorf("api key %s was revoked", id)
+	}
+	if !key.ExpiresAt.IsZero() && time.Now().After(key.ExpiresAt) {
+		return db.APIKey{}, fmt.Errorf("api key %s expired", id)
+	}
+	k.touch(id, logger)
+	return key, nil
+}
+
+func (k *APIKeys) lookup(id string) (db.APIKey, bool, error) {
+	k.mu.Lock()
+	cached, ok := k.cache[id]
+	k.mu.Unlock()
+	if ok && time.Since(cached.fetched) < k.CacheTTL {
+		return cached.key, cached.found, nil
+	}
+
+	key, err := k.Store.GetAPIKey(id)
+	found := err == nil
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return db.APIKey{}, false, err
+	}
+	k.mu.Lock()
+	k.cache[id] = cachedAPIKey{key: key, found: found, fetched: time.Now()}
+	k.mu.Unlock()
+	return key, found, nil
+}
+
+// touch records the last used time in the background, so it doesn't slow down the request
+func (k *APIKeys) touch(id string, logger reconcile.Logger) {
+	now := time.Now()
+	k.mu.Lock()
+	if now.Sub(k.touched[id]) < apiKeyTouchInterval {
+		k.mu.Unlock()
+		return
+	}
+	k.touched[id] = now
+	k.mu.Unlock()
+
+	k.touching.Add(1)
+	go func() {
+		defer k.touching.Done()
+		err := k.Store.TouchAPIKey(id, now)
+		if err != nil {
+			logger.Warnf("Failed to update last used time for api key %s: %s", id, err)
+		}
+	}()
+}
+
+// Wait blocks until last used times being written have been saved
+func (k *APIKeys) Wait() {
+	k.touching.Wait()
+}
+
+// forget drops a key from the cache so a revocation applies here immediately
+func (k *APIKeys) forget(id string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.cache, id)
+}
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// optional, RFC 3339
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type createAPIKeyResponse struct {
+	// only ever returned here, store it somewhere safe
+	Key    string    `json:"key"`
+	APIKey db.APIKey `json:"apiKey"`
+}
+
+func (h *Handlers) CreateAPIKey(c echo.Context) error {
+	var req createAPIKeyRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid api key request").WithInternal(err)
+	}
+	if len(strings.TrimSpace(req.Name)) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+	}
+	if !req.ExpiresAt.IsZero() && req.ExpiresAt.Before(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "expiresAt must be in the future")
+	}
+	err = checkGrantable(c, req.Scopes)
+	if err != nil {
+		return err
+	}
+	return h.issueAPIKey(c, req.Name, req.Scopes, req.ExpiresAt)
+}
+
+func (h *Handlers) ListAPIKeys(c echo.Context) error {
+	keys, err := h.APIKeys.Store.ListAPIKeys()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to list api keys").WithInternal(err)
+	}
+	if keys == nil {
+		keys = []db.APIKey{}
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+func (h *Handlers) RevokeAPIKey(c echo.Context) error {
+	id := c.Param("id")
+	err := h.APIKeys.Store.RevokeAPIKey(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "no api key with that id")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to revoke api key").WithInternal(err)
+	}
+	h.APIKeys.forget(id)
+	c.Logger().Infof("%s revoked api key %s", AuthorizedUser(c), id)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RotateAPIKey issues a replacement with the same name, scopes and lifetime,
+// then revokes the old key. If the old key can't be revoked, the replacement
+// is revoked too so the caller keeps using the old one and can retry.
+func (h *Handlers) RotateAPIKey(c echo.Context) error {
+	id := c.Param("id")
+	old, err := h.APIKeys.Store.GetAPIKey(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "no api key with that id")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get api key").WithInternal(err)
+	}
+	if old.Revoked {
+		return echo.NewHTTPError(http.StatusConflict, "api key is already revoked")
+	}
+	err = checkGrantable(c, old.Scopes)
+	if err != nil {
+		return err
+	}
+
+	var expires time.Time
+	if !old.ExpiresAt.IsZero() {
+		expires = time.Now().Add(old.ExpiresAt.Sub(old.CreatedAt))
+	}
+	full, key, err := h.createAPIKey(c, old.Name, old.Scopes, expires)
+	if err != nil {
+		return err
+	}
+
+	err = h.APIKeys.Store.RevokeAPIKey(id)
+	if err != nil {
+		undoErr := h.APIKeys.Store.RevokeAPIKey(key.ID)
+		if undoErr != nil {
+			c.Logger().Errorf("Failed to revoke replacement api key %s: %s", key.ID, undoErr)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to revoke old api key").WithInternal(err)
+	}
+	h.APIKeys.forget(id)
+	c.Logger().Infof("%s rotated api key %s to %s", AuthorizedUser(c), id, key.ID)
+	return c.JSON(http.StatusCreated, createAPIKeyResponse{Key: full, APIKey: key})
+}
+
+func (h *Handlers) issueAPIKey(c echo.Context, name string, scopes []string, expires time.Time) error {
+	full, key, err := h.createAPIKey(c, name, scopes, expires)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, createAPIKeyResponse{Key: full, APIKey: key})
+}
+
+// createAPIKey saves a new key and returns it, along with the full key to hand out
+func (h *Handlers) createAPIKey(c echo.Context, name string, scopes []string, expires time.Time) (string, db.APIKey, error) {
+	full, key, err := newAPIKey(name, scopes, expires, AuthorizedUser(c))
+	if err != nil {
+		return "", db.APIKey{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to generate api key").WithInternal(err)
+	}
+	err = h.APIKeys.Store.CreateAPIKey(key)
+	if err != nil {
+		return "", db.APIKey{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to save api key").WithInternal(err)
+	}
+	c.Logger().Infof("%s created api key %s (%s) with scopes [%s]", key.CreatedBy, key.ID, key.Name, strings.Join(key.Scopes, " "))
+	return full, key, nil
+}
+
+// checkGrantable stops callers from handing out known scopes they don't hold themselves
+func checkGrantable(c echo.Context, scopes []string) error {
+	if len(scopes) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "at least one scope is required")
+	}
+	for _, s := range scopes {
+		if !knownScope(s) {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown scope: %s", s))
+		}
+		if !HasScope(c, s) {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("can't grant a scope you don't have: %s", s))
+		}
+	}
+	return nil
+}
+
+func knownScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
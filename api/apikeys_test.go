@@ -0,0 +1,361 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theforgeinitiative/integrations/db"
+)
+
+// fakeKeyStore keeps api keys in memory. failRevoke makes revoking that id fail.
+type fakeKeyStore struct {
+	mu         sync.Mutex
+	keys       map[string]db.APIKey
+	touches    map[string]int
+	gets       int
+	failRevoke string
+}
+
+func newFakeKeyStore(keys ...db.APIKey) *fakeKeyStore {
+	s := &fakeKeyStore{keys: make(map[string]db.APIKey), touches: make(map[string]int)}
+	for _, k := range keys {
+		s.keys[k.ID] = k
+	}
+	return s
+}
+
+func (s *fakeKeyStore) CreateAPIKey(key db.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *fakeKeyStore) GetAPIKey(id string) (db.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	key, ok := s.keys[id]
+	if !ok {
+		return db.APIKey{}, db.ErrNotFound
+	}
+	return key, nil
+}
+
+func (s *fakeKeyStore) ListAPIKeys() ([]db.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []db.APIKey
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s *fakeKeyStore) RevokeAPIKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == s.failRevoke {
+		return errors.New("firestore is down")
+	}
+	key, ok := s.keys[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	key.Revoked = true
+	key.RevokedAt = time.Now()
+	s.keys[id] = key
+	return nil
+}
+
+func (s *fakeKeyStore) TouchAPIKey(id string, used time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touches[id]++
+	return nil
+}
+
+func (s *fakeKeyStore) key(id string) db.APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[id]
+}
+
+func testAPIKey(t *testing.T, scopes ...string) (string, db.APIKey) {
+	t.Helper()
+	full, key, err := newAPIKey("ci", scopes, time.Time{}, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return full, key
+}
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		token      string
+		id, secret string
+		ok         bool
+	}{
+		{"tfi_abc_secret", "abc", "secret", true},
+		{"tfi_abc_sec_ret", "abc", "sec_ret", true},
+		{"tfi_abc", "", "", false},
+		{"tfi__secret", "", "", false},
+		{"tfi_abc_", "", "", false},
+	}
+	for _, tt := range tests {
+		id, secret, ok := parseAPIKey(tt.token)
+		if ok != tt.ok || (ok && (id != tt.id || secret != tt.secret)) {
+			t.Errorf("parseAPIKey(%q) = %q, %q, %v", tt.token, id, secret, ok)
+		}
+	}
+}
+
+func TestHashSecret(t *testing.T) {
+	// sha256 of "secret"
+	want := "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+	if got := hashSecret("secret"); got != want {
+		t.Errorf("hashSecret = %s, want %s", got, want)
+	}
+	full, key := testAPIKey(t, ScopeReconcileRead)
+	_, secret, _ := parseAPIKey(full)
+	if key.Hash != hashSecret(secret) || strings.Contains(full, key.Hash) {
+		t.Error("stored hash doesn't match the handed out secret")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	full, key := testAPIKey(t, ScopeReconcileRead)
+	revokedFull, revoked := testAPIKey(t, ScopeReconcileRead)
+	revoked.Revoked = true
+	expiredFull, expired := testAPIKey(t, ScopeReconcileRead)
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	wrongSecret := full[:strings.LastIndex(full, "_")+1] + "guess"
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", full, true},
+		{"wrong secret", wrongSecret, false},
+		{"unknown id", "tfi_0000000000000000_secret", false},
+		{"malformed", "tfi_nounderscore", false},
+		{"revoked", revokedFull, false},
+		{"expired", expiredFull, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := NewAPIKeys(newFakeKeyStore(key, revoked, expired))
+			got, err := keys.Authenticate(tt.token, echo.New().Logger)
+			keys.Wait()
+			if tt.ok && (err != nil || got.ID != key.ID) {
+				t.Errorf("Authenticate = %+v, %v", got, err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestAuthenticateCache(t *testing.T) {
+	full, key := testAPIKey(t, ScopeReconcileRead)
+	store := newFakeKeyStore(key)
+	keys := NewAPIKeys(store)
+	keys.CacheTTL = time.Hour
+
+	for i := 0; i < 3; i++ {
+		_, err := keys.Authenticate(full, echo.New().Logger)
+		if err != nil {
+			t.Fatalf("Authenticate: %s", err)
+		}
+	}
+	keys.Wait()
+	if store.gets != 1 {
+		t.Errorf("store was read %d times, want 1", store.gets)
+	}
+	if store.touches[key.ID] != 1 {
+		t.Errorf("last used was written %d times, want 1", store.touches[key.ID])
+	}
+
+	// revoked on another instance, so it's trusted until the cache expires
+	store.RevokeAPIKey(key.ID)
+	_, err := keys.Authenticate(full, echo.New().Logger)
+	if err != nil {
+		t.Errorf("cached key was rejected before the TTL: %s", err)
+	}
+	keys.CacheTTL = 0
+	_, err = keys.Authenticate(full, echo.New().Logger)
+	if err == nil {
+		t.Error("revoked key still accepted after the cache expired")
+	}
+
+	// a revocation on this instance applies right away
+	full2, key2 := testAPIKey(t, ScopeReconcileRead)
+	store.CreateAPIKey(key2)
+	keys.CacheTTL = time.Hour
+	keys.Authenticate(full2, echo.New().Logger)
+	store.RevokeAPIKey(key2.ID)
+	keys.forget(key2.ID)
+	_, err = keys.Authenticate(full2, echo.New().Logger)
+	if err == nil {
+		t.Error("forgotten key was still served from the cache")
+	}
+	keys.Wait()
+}
+
+func newKeyContext(method, target, body string, scopes ...string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	setAuthorized(c, "admin@example.org", scopes)
+	return c, rec
+}
+
+func TestCheckGrantable(t *testing.T) {
+	tests := []struct {
+		name   string
+		held   []string
+		grant  []string
+		status int
+	}{
+		{"held", []string{ScopeAPIKeysAdmin, ScopeReconcileRead}, []string{ScopeReconcileRead}, 0},
+		{"no scopes", []string{ScopeAPIKeysAdmin}, nil, http.StatusBadRequest},
+		{"unknown scope", []string{ScopeAPIKeysAdmin}, []string{"everything"}, http.StatusBadRequest},
+		{"escalation", []string{ScopeAPIKeysAdmin, ScopeReconcileRead}, []string{ScopeReconcileWrite}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newKeyContext(http.MethodPost, "/", "", tt.held...)
+			err := checkGrantable(c, tt.grant)
+			if tt.status == 0 {
+				if err != nil {
+					t.Errorf("checkGrantable: %s", err)
+				}
+				return
+			}
+			if code := httpCode(t, err); code != tt.status {
+				t.Errorf("status = %d, want %d", code, tt.status)
+			}
+		})
+	}
+}
+
+func TestCreateAPIKeyEscalation(t *testing.T) {
+	store := newFakeKeyStore()
+	h := &Handlers{APIKeys: NewAPIKeys(store)}
+	c, _ := newKeyContext(http.MethodPost, "/api/v1/apikeys", `{"name":"ci","scopes":["reconcile:write"]}`, ScopeAPIKeysAdmin)
+
+	err := h.CreateAPIKey(c)
+	if code := httpCode(t, err); code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", code, http.StatusForbidden)
+	}
+	if len(store.keys) != 0 {
+		t.Error("key was created anyway")
+	}
+}
+
+func rotateContext(id string, scopes ...string) (echo.Context, *httptest.ResponseRecorder) {
+	c, rec := newKeyContext(http.MethodPost, "/", "", scopes...)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	return c, rec
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	oldFull, old := testAPIKey(t, ScopeReconcileRead)
+	old.ExpiresAt = old.CreatedAt.Add(24 * time.Hour)
+	store := newFakeKeyStore(old)
+	h := &Handlers{APIKeys: NewAPIKeys(store)}
+	c, rec := rotateContext(old.ID, ScopeAPIKeysAdmin, ScopeReconcileRead)
+
+	err := h.RotateAPIKey(c)
+	if err != nil {
+		t.Fatalf("RotateAPIKey: %s", err)
+	}
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var resp createAPIKeyResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	if !store.key(old.ID).Revoked {
+		t.Error("old key wasn't revoked")
+	}
+	replacement := store.key(resp.APIKey.ID)
+	if replacement.Revoked || replacement.Name != old.Name || len(replacement.Scopes) != 1 || replacement.Scopes[0] != ScopeReconcileRead {
+		t.Errorf("replacement = %+v", replacement)
+	}
+	if lifetime := replacement.ExpiresAt.Sub(replacement.CreatedAt); lifetime < 23*time.Hour || lifetime > 25*time.Hour {
+		t.Errorf("replacement lifetime = %s, want about a day", lifetime)
+	}
+	if _, err := h.APIKeys.Authenticate(oldFull, echo.New().Logger); err == nil {
+		t.Error("old key still works after rotation")
+	}
+	if _, err := h.APIKeys.Authenticate(resp.Key, echo.New().Logger); err != nil {
+		t.Errorf("replacement key doesn't work: %s", err)
+	}
+	h.APIKeys.Wait()
+}
+
+func TestRotateAPIKeyRevokeFails(t *testing.T) {
+	oldFull, old := testAPIKey(t, ScopeReconcileRead)
+	store := newFakeKeyStore(old)
+	store.failRevoke = old.ID
+	h := &Handlers{APIKeys: NewAPIKeys(store)}
+	c, _ := rotateContext(old.ID, ScopeAPIKeysAdmin, ScopeReconcileRead)
+
+	err := h.RotateAPIKey(c)
+	if code := httpCode(t, err); code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", code, http.StatusInternalServerError)
+	}
+	// nothing usable was handed out, so the replacement mustn't be live either
+	for id, key := range store.keys {
+		if id != old.ID && !key.Revoked {
+			t.Errorf("replacement %s is still valid", id)
+		}
+	}
+	if _, err := h.APIKeys.Authenticate(oldFull, echo.New().Logger); err != nil {
+		t.Errorf("old key should keep working so the caller can retry: %s", err)
+	}
+	h.APIKeys.Wait()
+}
+
+func TestRotateAPIKeyRejected(t *testing.T) {
+	_, revoked := testAPIKey(t, ScopeReconcileRead)
+	revoked.Revoked = true
+	_, writer := testAPIKey(t, ScopeReconcileWrite)
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{"unknown", "missing", http.StatusNotFound},
+		{"revoked", revoked.ID, http.StatusConflict},
+		{"escalation", writer.ID, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeKeyStore(revoked, writer)
+			h := &Handlers{APIKeys: NewAPIKeys(store)}
+			c, _ := rotateContext(tt.id, ScopeAPIKeysAdmin, ScopeReconcileRead)
+
+			err := h.RotateAPIKey(c)
+			if code := httpCode(t, err); code != tt.status {
+				t.Errorf("status = %d, want %d", code, tt.status)
+			}
+			if len(store.keys) != 2 {
+				t.Error("a replacement was created")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theforgeinitiative/integrations/checkmein"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+const defaultAttendanceWindow = 90 * 24 * time.Hour
+
+type AttendanceReport struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Visits  int       `json:"visits"`
+	Updated []string  `json:"updated"`
+	// barcodes seen in CheckMeIn that don't belong to any contact
+	Unmatched []string `json:"unmatched,omitempty"`
+	Errored   []string `json:"errored,omitempty"`
+}
+
+// ImportAttendance pulls CheckMeIn visits for a date range and writes visit
+// counts to the matching contacts. Defaults to the last 90 days.
+func (h *Handlers) ImportAttendance(c echo.Context) error {
+	end := time.Now()
+	start := end.Add(-defaultAttendanceWindow)
+	var err error
+	if param := c.QueryParam("start"); len(param) > 0 {
+		start, err = time.ParseInLocation(sfdc.DateFormat, param, time.Local)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid format for start param, expected YYYY-MM-DD")
+		}
+	}
+	if param := c.QueryParam("end"); len(param) > 0 {
+		end, err = time.ParseInLocation(sfdc.DateFormat, param, time.Local)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid format for end param, expected YYYY-MM-DD")
+		}
+	}
+	if end.Before(start) {
+		return echo.NewHTTPError(http.StatusBadRequest, "end must not be before start")
+	}
+
+	visits, err := h.CheckMeInClient.Visits(start, end)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to retrieve checkmein visits").WithInternal(err)
+	}
+	summaries := checkmein.SummarizeVisits(visits)
+
+	barcodes := make([]string, 0, len(summaries))
+	for b := range summaries {
+		barcodes = append(barcodes, b)
+	}
+	contacts, err := h.SFClient.FindContactsByBarcodes(barcodes)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to retrieve contacts from sfdc").WithInternal(err)
+	}
+
+	report := AttendanceReport{
+		Start:   start,
+		End:     end,
+		Visits:  len(visits),
+		Updated: []string{},
+	}
+	respStatus := http.StatusOK
+	matched := make(map[string]bool, len(contacts))
+	for _, contact := range contacts {
+		s, ok := summaries[contact.Barcode]
+		if !ok {
+			continue
+		}
+		matched[contact.Barcode] = true
+		err := h.SFClient.SetVisitStats(contact.ID, s.Count, s.LastVisit)
+		if err != nil {
+			c.Logger().Errorf("Failed to set visit stats for %s: %s", contact.DisplayName, err)
+			report.Errored = append(report.Errored, contact.DisplayName)
+			respStatus = http.StatusMultiStatus
+			continue
+		}
+		report.Updated = append(report.Updated, contact.DisplayName)
+	}
+	for _, b := range barcodes {
+		if !matched[b] {
+			report.Unmatched = append(report.Unmatched, b)
+		}
+	}
+	c.Logger().Infof("Imported %d visits for %d contacts", len(visits), len(report.Updated))
+
+	return c.JSON(respStatus, report)
+}
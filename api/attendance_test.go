@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/gocarina/gocsv"
+	"github.com/labstack/echo/v4"
+	"github.com/theforgeinitiative/integrations/checkmein"
+	"github.com/theforgeinitiative/integrations/checkmein/checkmeintest"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+// contactRecord is a contact as a SOQL query returns it. Related objects need
+// their url attribute or simpleforce won't read them.
+func contactRecord(id, barcode, name string) map[string]interface{} {
+	return map[string]interface{}{
+		"attributes":                     map[string]string{"type": "Contact", "url": "/services/data/v54.0/sobjects/Contact/" + id},
+		"Id":                             id,
+		"TFI_Barcode_for_Button__c":      barcode,
+		"TFI_Display_Name_for_Button__c": name,
+		"Account": map[string]interface{}{
+			"attributes":                 map[string]string{"type": "Account", "url": "/services/data/v54.0/sobjects/Account/001" + id},
+			"Id":                         "001" + id,
+			"npsp__Membership_Status__c": "Current",
+		},
+	}
+}
+
+func newAttendanceHandlers(t *testing.T) (*Handlers, *fakeSFDC) {
+	t.Helper()
+	f, err := os.Open("../checkmein/testdata/visits.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var visits []checkmein.Visit
+	err = gocsv.Unmarshal(f, &visits)
+	if err != nil {
+		t.Fatalf("failed to parse fixture: %s", err)
+	}
+
+	cmi := checkmeintest.NewServer("admin", "secret")
+	t.Cleanup(cmi.Close)
+	cmi.SetVisits(visits)
+	cmiClient := checkmein.NewClient(cmi.URL, "admin", "secret")
+
+	sf, sfClient := newFakeSFDC(t)
+	// Sam's barcode isn't on any contact
+	sf.records = []map[string]interface{}{contactRecord("003000000000001", "100", "Jane D")}
+	return &Handlers{SFClient: sfClient, CheckMeInClient: &cmiClient}, sf
+}
+
+func TestImportAttendance(t *testing.T) {
+	h, sf := newAttendanceHandlers(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/attendance?start=2024-03-01&end=2024-03-31", nil)
+	rec := httptest.NewRecorder()
+
+	err := h.ImportAttendance(echo.New().NewContext(req, rec))
+	if err != nil {
+		t.Fatalf("ImportAttendance: %s", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var report AttendanceReport
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	sort.Strings(report.Unmatched)
+	if report.Visits != 6 || len(report.Updated) != 1 || report.Updated[0] != "Jane D" {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.Unmatched) != 1 || report.Unmatched[0] != "200" {
+		t.Errorf("unmatched = %v, want [200]", report.Unmatched)
+	}
+
+	update := sf.Updates()["003000000000001"]
+	if update == nil {
+		t.Fatalf("contact wasn't updated, updates = %v", sf.Updates())
+	}
+	if update[sfdc.DefaultVisitCountField] != float64(3) {
+		t.Errorf("%s = %v, want 3", sfdc.DefaultVisitCountField, update[sfdc.DefaultVisitCountField])
+	}
+	if update[sfdc.DefaultLastVisitField] != "2024-03-08" {
+		t.Errorf("%s = %v, want 2024-03-08", sfdc.DefaultLastVisitField, update[sfdc.DefaultLastVisitField])
+	}
+}
+
+func TestImportAttendanceCustomFields(t *testing.T) {
+	h, sf := newAttendanceHandlers(t)
+	h.SFClient.VisitCountField = "Visits__c"
+	h.SFClient.LastVisitField = "Last_Visit__c"
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/attendance", nil)
+
+	err := h.ImportAttendance(echo.New().NewContext(req, httptest.NewRecorder()))
+	if err != nil {
+		t.Fatalf("ImportAttendance: %s", err)
+	}
+	update := sf.Updates()["003000000000001"]
+	if update["Visits__c"] != float64(3) || update["Last_Visit__c"] != "2024-03-08" {
+		t.Errorf("update = %v", update)
+	}
+	if _, ok := update[sfdc.DefaultVisitCountField]; ok {
+		t.Errorf("default field written too: %v", update)
+	}
+}
+
+func TestImportAttendanceBadRange(t *testing.T) {
+	h, _ := newAttendanceHandlers(t)
+	for _, q := range []string{"start=03/01/2024", "end=tomorrow", "start=2024-03-31&end=2024-03-01"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/attendance?"+q, nil)
+		err := h.ImportAttendance(echo.New().NewContext(req, httptest.NewRecorder()))
+		if code := httpCode(t, err); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", q, code)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/theforgeinitiative/integrations/discord"
 	"github.com/theforgeinitiative/integrations/groups"
 	"github.com/theforgeinitiative/integrations/mail"
+	"github.com/theforgeinitiative/integrations/mq"
 	"github.com/theforgeinitiative/integrations/sfdc"
 )
 
@@ -22,6 +23,21 @@ type Handlers struct {
 	DBClient        *db.Client
 	GroupsClient    *groups.Client
 	GroupExceptions []string
+	// CheckMeIn barcodes reconcile leaves alone
+	CheckMeInExceptions []string
+	// contacts in this SFDC campaign become managers of the members group
+	ManagerCampaign string
+	ManagerStatus   string
 	CheckMeInClient *checkmein.Client
+	// optional, single-contact reconciles use it instead of exporting the roster
+	CheckMeInRoster *checkmein.RosterCache
 	EmailClient     *mail.Client
+	// optional, events are skipped when nil
+	MQClient *mq.Client
+	// optional, needs Firestore
+	APIKeys *APIKeys
+	// optional, lookups always go to SFDC when nil
+	MemberCache *MemberCache
+	// verifies Salesforce webhooks
+	SFDCWebhook SFDCWebhookConfig
 }
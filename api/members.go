@@ -0,0 +1,185 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+const defaultMemberCacheTTL = time.Minute
+
+// prune expired entries once the cache grows past this
+const memberCachePruneSize = 1000
+
+// MemberView is all a lookup reveals about a contact. Dates need ScopeMembersDetails.
+type MemberView struct {
+	DisplayName string `json:"displayName"`
+	Status      string `json:"status"`
+	Current     bool   `json:"current"`
+	EndDate     string `json:"endDate,omitempty"`
+	WaiverDate  string `json:"waiverDate,omitempty"`
+}
+
+type MemberLookupResponse struct {
+	Members []MemberView `json:"members"`
+}
+
+func memberView(contact sfdc.Contact, details bool) MemberView {
+	v := MemberView{
+		DisplayName: contact.DisplayName,
+		Status:      contact.MembershipStatus,
+		Current:     contact.CurrentMember(),
+	}
+	if details {
+		v.EndDate = contact.MembershipEndDate
+		v.WaiverDate = contact.WaiversSignedDate
+	}
+	return v
+}
+
+type cachedLookup struct {
+	contacts []sfdc.Contact
+	expires  time.Time
+}
+
+// MemberCache holds recent lookups so kiosks polling the same barcode don't hit SFDC every time
+type MemberCache struct {
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedLookup
+}
+
+func NewMemberCache(ttl time.Duration) *MemberCache {
+	if ttl <= 0 {
+		ttl = defaultMemberCacheTTL
+	}
+	return &MemberCache{TTL: ttl, entries: make(map[string]cachedLookup)}
+}
+
+func (m *MemberCache) get(key string) ([]sfdc.Contact, bool) {
+	if m == nil {
+		return nil, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.contacts, true
+}
+
+func (m *MemberCache) set(key string, contacts []sfdc.Contact) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if len(m.entries) >= memberCachePruneSize {
+		for k, e := range m.entries {
+			if now.After(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+	}
+	m.entries[key] = cachedLookup{contacts: contacts, expires: now.Add(m.TTL)}
+}
+
+// LookupMember finds contacts by exactly one of barcode, email, discord_id or
+// hid and pid together. Dates are only included for callers with ScopeMembersDetails.
+func (h *Handlers) LookupMember(c echo.Context) error {
+	key, lookup, err := h.memberLookup(c)
+	if err != nil {
+		return err
+	}
+
+	contacts, ok := h.MemberCache.get(key)
+	if !ok {
+		contacts, err = lookup()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to look up member in sfdc").WithInternal(err)
+		}
+		h.MemberCache.set(key, contacts)
+	}
+	if len(contacts) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "no matching member")
+	}
+
+	details := HasScope(c, ScopeMembersDetails)
+	resp := MemberLookupResponse{Members: make([]MemberView, len(contacts))}
+	for i, contact := range contacts {
+		resp.Members[i] = memberView(contact, details)
+	}
+	c.Logger().Infof("%s looked up member by %s", AuthorizedUser(c), strings.SplitN(key, ":", 2)[0])
+	return c.JSON(http.StatusOK, resp)
+}
+
+// memberLookup picks the SFDC query for the request and a cache key for it
+func (h *Handlers) memberLookup(c echo.Context) (string, func() ([]sfdc.Contact, error), error) {
+	barcode := strings.TrimSpace(c.QueryParam("barcode"))
+	email := strings.ToLower(strings.TrimSpace(c.QueryParam("email")))
+	discordID := strings.TrimSpace(c.QueryParam("discord_id"))
+	hid := strings.TrimSpace(c.QueryParam("hid"))
+	pid := strings.TrimSpace(c.QueryParam("pid"))
+
+	given := 0
+	for _, p := range []string{barcode, email, discordID, hid + pid} {
+		if len(p) > 0 {
+			given++
+		}
+	}
+	if given != 1 {
+		return "", nil, echo.NewHTTPError(http.StatusBadRequest, "provide exactly one of barcode, email, discord_id or hid and pid")
+	}
+
+	switch {
+	case len(barcode) > 0:
+		return "barcode:" + barcode, func() ([]sfdc.Contact, error) {
+			return h.SFClient.FindContactsByBarcodes([]string{barcode})
+		}, nil
+	case len(email) > 0:
+		addr, err := netmail.ParseAddress(email)
+		if err != nil || addr.Address != email {
+			return "", nil, echo.NewHTTPError(http.StatusBadRequest, "invalid email")
+		}
+		return "email:" + email, func() ([]sfdc.Contact, error) {
+			return h.SFClient.FindContactsByEmail(email)
+		}, nil
+	case len(discordID) > 0:
+		if strings.Trim(discordID, "0123456789") != "" {
+			return "", nil, echo.NewHTTPError(http.StatusBadRequest, "invalid discord_id")
+		}
+		return "discord:" + discordID, func() ([]sfdc.Contact, error) {
+			contact, err := h.SFClient.GetContactByDiscordID(discordID)
+			return optionalContact(contact, err)
+		}, nil
+	default:
+		if len(hid) == 0 || len(pid) == 0 {
+			return "", nil, echo.NewHTTPError(http.StatusBadRequest, "hid and pid must be provided together")
+		}
+		return fmt.Sprintf("ids:%s/%s", hid, pid), func() ([]sfdc.Contact, error) {
+			contact, err := h.SFClient.FindContactByIDs(hid, pid)
+			return optionalContact(contact, err)
+		}, nil
+	}
+}
+
+// the single contact lookups report a missing contact as an error
+func optionalContact(contact sfdc.Contact, err error) ([]sfdc.Contact, error) {
+	if errors.Is(err, sfdc.ErrContactNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []sfdc.Contact{contact}, nil
+}
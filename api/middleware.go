@@ -2,8 +2,9 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
 	"net/url"
 	"strings"
 	"time"
@@ -17,14 +18,31 @@ import (
 const AuthMethodHeader = "X-Auth-Method"
 
 type AuthMiddleware struct {
-	Audience             string
-	AllowedGCPPrincipals []string
+	Audience string
+	// scopes granted to each trusted GCP service account. Keys are either an
+	// exact email or *@domain to trust every account in a domain.
+	GCPPrincipals map[string][]string
+	// optional, API keys are rejected when nil
+	APIKeys *APIKeys
 
-	jwtValidator *validator.Validator
+	// swappable so tests can use locally signed tokens
+	JWTValidator     TokenValidator
+	IDTokenValidator func(ctx context.Context, token, audience string) (*idtoken.Payload, error)
+}
+
+// TokenValidator is satisfied by the Auth0 validator
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (interface{}, error)
 }
 
 type CustomClaims struct {
 	Scope string `json:"scope"`
+	// set instead of scope when Auth0 RBAC is enabled
+	Permissions []string `json:"permissions"`
+}
+
+func (c CustomClaims) Scopes() []string {
+	return append(strings.Fields(c.Scope), c.Permissions...)
 }
 
 // Validate does nothing for this example, but we need
@@ -33,7 +51,10 @@ func (c CustomClaims) Validate(ctx context.Context) error {
 	return nil
 }
 
-func NewAuthMiddleware(issuer, audience string, gcpPrincipals []string) (AuthMiddleware, error) {
+func NewAuthMiddleware(issuer, audience string, gcpPrincipals map[string][]string) (AuthMiddleware, error) {
+	if len(audience) == 0 {
+		return AuthMiddleware{}, errors.New("an audience is required")
+	}
 	issuerURL, err := url.Parse(issuer)
 	if err != nil {
 		return AuthMiddleware{}, fmt.Errorf("failed to parse the issuer url: %w", err)
@@ -53,52 +74,111 @@ func NewAuthMiddleware(issuer, audience string, gcpPrincipals []string) (AuthMid
 		validator.WithAllowedClockSkew(time.Minute),
 	)
 	if err != nil {
-		log.Fatalf("Failed to set up the jwt validator")
+		return AuthMiddleware{}, fmt.Errorf("failed to set up the jwt validator: %w", err)
+	}
+
+	// config keys come back lowercased, so match principals the same way
+	principals := make(map[string][]string, len(gcpPrincipals))
+	for p, scopes := range gcpPrincipals {
+		principals[strings.ToLower(p)] = scopes
 	}
 
 	return AuthMiddleware{
-		AllowedGCPPrincipals: gcpPrincipals,
-		jwtValidator:         jwtValidator,
+		Audience:         audience,
+		GCPPrincipals:    principals,
+		JWTValidator:     jwtValidator,
+		IDTokenValidator: idtoken.Validate,
 	}, nil
 }
 
 func (a *AuthMiddleware) Require(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		splitToken := strings.Split(c.Request().Header.Get("Authorization"), "Bearer ")
-		if len(splitToken) < 2 {
-			return echo.NewHTTPError(401)
+		reqToken, ok := bearerToken(c.Request().Header.Get("Authorization"))
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnauthorized, "expected an Authorization: Bearer header")
+		}
+		ctx := c.Request().Context()
+
+		if isAPIKey(reqToken) {
+			if a.APIKeys == nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "api keys are not enabled")
+			}
+			key, err := a.APIKeys.Authenticate(reqToken, c.Logger())
+			if err != nil {
+				c.Logger().Infof("Failed to validate api key: %s", err)
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid api key provided")
+			}
+			setAuthorized(c, "apikey:"+key.Name, key.Scopes)
+			return next(c)
 		}
-		reqToken := splitToken[1]
 
 		// Use GCP auth if header is present
 		if c.Request().Header.Get(AuthMethodHeader) == "gcp" {
-			tok, err := idtoken.Validate(context.Background(), reqToken, a.Audience)
+			tok, err := a.IDTokenValidator(ctx, reqToken, a.Audience)
 			if err != nil {
-				return echo.NewHTTPError(401, "invalid gcp token provided")
+				c.Logger().Infof("Failed to validate gcp token: %s", err)
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid gcp token provided")
+			}
+			email, ok := tok.Claims["email"].(string)
+			if !ok || len(email) == 0 {
+				return echo.NewHTTPError(http.StatusUnauthorized, "gcp token did not have email claim")
+			}
+			if verified, _ := tok.Claims["email_verified"].(bool); !verified {
+				c.Logger().Infof("ID token email was not verified: %s", email)
+				return echo.NewHTTPError(http.StatusUnauthorized, "gcp token email is not verified")
 			}
 			// match against trusted principals
-			email, ok := tok.Claims["email"]
+			scopes, ok := a.principalScopes(email)
 			if !ok {
-				return echo.NewHTTPError(401, "gcp token did not have email claim")
-			}
-			for _, p := range a.AllowedGCPPrincipals {
-				if p == email {
-					c.Logger().Printf("Validated request with GCP credentials")
-					c.Set("authorized_user", email)
-					return next(c)
-				}
+				c.Logger().Infof("ID token principal was not authorized: %s", email)
+				return echo.NewHTTPError(http.StatusUnauthorized)
 			}
-			c.Logger().Infof("ID token principal was not authorized: %s", email)
-			return echo.NewHTTPError(401)
+			c.Logger().Printf("Validated request with GCP credentials")
+			setAuthorized(c, email, scopes)
+			return next(c)
 		}
 
-		// TODO: do some actual permissions checking. For now, just validate token
-		claims, err := a.jwtValidator.ValidateToken(c.Request().Context(), reqToken)
+		claims, err := a.JWTValidator.ValidateToken(ctx, reqToken)
 		if err != nil {
 			c.Logger().Infof("Failed to validate token: %s", err)
-			return echo.NewHTTPError(401)
+			return echo.NewHTTPError(http.StatusUnauthorized)
+		}
+		validated, ok := claims.(*validator.ValidatedClaims)
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnauthorized)
 		}
-		c.Set("authorized_user", claims.(*validator.ValidatedClaims).RegisteredClaims.Subject)
+		var scopes []string
+		if custom, ok := validated.CustomClaims.(*CustomClaims); ok {
+			scopes = custom.Scopes()
+		}
+		setAuthorized(c, validated.RegisteredClaims.Subject, scopes)
 		return next(c)
 	}
 }
+
+// principalScopes prefers an exact match over a *@domain wildcard
+func (a *AuthMiddleware) principalScopes(email string) ([]string, bool) {
+	email = strings.ToLower(email)
+	scopes, ok := a.GCPPrincipals[email]
+	if ok {
+		return scopes, true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil, false
+	}
+	scopes, ok = a.GCPPrincipals["*"+email[at:]]
+	return scopes, ok
+}
+
+// bearerToken only accepts "Bearer <token>", with the scheme in any case
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	if len(token) == 0 || strings.ContainsAny(token, " \t\r\n") {
+		return "", false
+	}
+	return token, true
+}
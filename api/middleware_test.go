@@ -0,0 +1,390 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/api/idtoken"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const testAudience = "https://integrations.example.org"
+
+// testIssuer signs tokens with a local key and serves it as a JWKS, like an
+// OIDC provider would
+type testIssuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	signer jose.Signer
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: "test-key", Algorithm: string(jose.RS256)},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iss := &testIssuer{key: key, signer: signer}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": iss.URL + "/jwks.json"})
+	})
+	mux.HandleFunc("/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test-key", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func (iss *testIssuer) issuer() string {
+	return iss.URL + "/"
+}
+
+func (iss *testIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	tok, err := jwt.Signed(iss.signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func (iss *testIssuer) accessToken(t *testing.T, audience, scope string) string {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   iss.issuer(),
+		"sub":   "client@clients",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"scope": scope,
+	}
+	if len(audience) > 0 {
+		claims["aud"] = audience
+	}
+	return iss.sign(t, claims)
+}
+
+func (iss *testIssuer) idToken(t *testing.T, email string, verified bool) string {
+	now := time.Now()
+	return iss.sign(t, map[string]interface{}{
+		"iss":            "https://accounts.google.com",
+		"aud":            testAudience,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"email":          email,
+		"email_verified": verified,
+	})
+}
+
+// idTokenValidator checks ID tokens against the local key instead of Google's
+func (iss *testIssuer) idTokenValidator(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	err = parsed.Claims(&iss.key.PublicKey, &claims)
+	if err != nil {
+		return nil, err
+	}
+	aud, _ := claims["aud"].(string)
+	if aud != audience {
+		return nil, errors.New("audience provided does not match aud claim in the JWT")
+	}
+	return &idtoken.Payload{Audience: aud, Claims: claims}, nil
+}
+
+func newTestAuth(t *testing.T, iss *testIssuer) AuthMiddleware {
+	t.Helper()
+	auth, err := NewAuthMiddleware(iss.issuer(), testAudience, map[string][]string{
+		"scheduler@project.iam.gserviceaccount.com": {ScopeReconcileWrite},
+		"*@example.org": {ScopeReconcileRead},
+		// an exact match wins over the domain
+		"admin@example.org": {ScopeAPIKeysAdmin},
+	})
+	if err != nil {
+		t.Fatalf("NewAuthMiddleware: %s", err)
+	}
+	auth.IDTokenValidator = iss.idTokenValidator
+	return auth
+}
+
+type authorized struct {
+	User   string   `json:"user"`
+	Scopes []string `json:"scopes"`
+}
+
+// request runs one request through the middleware, returning the status and
+// who the handler saw
+func request(t *testing.T, auth AuthMiddleware, headers map[string]string) (int, authorized) {
+	t.Helper()
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, authorized{User: AuthorizedUser(c), Scopes: AuthorizedScopes(c)})
+	}, auth.Require)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	var got authorized
+	if rec.Code == http.StatusOK {
+		err := json.Unmarshal(rec.Body.Bytes(), &got)
+		if err != nil {
+			t.Fatalf("failed to decode response: %s", err)
+		}
+	}
+	return rec.Code, got
+}
+
+func TestNewAuthMiddlewareRequiresAudience(t *testing.T) {
+	iss := newTestIssuer(t)
+	_, err := NewAuthMiddleware(iss.issuer(), "", nil)
+	if err == nil {
+		t.Error("expected an error without an audience")
+	}
+}
+
+func TestRequireJWT(t *testing.T) {
+	iss := newTestIssuer(t)
+	auth := newTestAuth(t, iss)
+
+	status, got := request(t, auth, map[string]string{
+		"Authorization": "Bearer " + iss.accessToken(t, testAudience, "reconcile:read members:read"),
+	})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	want := authorized{User: "client@clients", Scopes: []string{ScopeMembersRead, ScopeReconcileRead}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("authorized = %+v, want %+v", got, want)
+	}
+}
+
+func TestRequireJWTRejected(t *testing.T) {
+	iss := newTestIssuer(t)
+	auth := newTestAuth(t, iss)
+	other := newTestIssuer(t)
+
+	tests := map[string]string{
+		"missing audience": iss.accessToken(t, "", "reconcile:read"),
+		"wrong audience":   iss.accessToken(t, "https://elsewhere.example.org", "reconcile:read"),
+		// same claims, signed by a key the issuer never published
+		"unknown key": other.sign(t, map[string]interface{}{
+			"iss": iss.issuer(),
+			"aud": testAudience,
+			"sub": "client@clients",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}),
+		"garbage": "not-a-jwt",
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			status, _ := request(t, auth, map[string]string{"Authorization": "Bearer " + token})
+			if status != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401", status)
+			}
+		})
+	}
+}
+
+func TestRequireMalformedHeader(t *testing.T) {
+	iss := newTestIssuer(t)
+	auth := newTestAuth(t, iss)
+	token := iss.accessToken(t, testAudience, "reconcile:read")
+
+	for name, header := range map[string]string{
+		"missing":      "",
+		"no scheme":    token,
+		"basic":        "Basic " + token,
+		"empty token":  "Bearer ",
+		"extra fields": "Bearer " + token + " extra",
+	} {
+		t.Run(name, func(t *testing.T) {
+			status, _ := request(t, auth, map[string]string{"Authorization": header})
+			if status != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401", status)
+			}
+		})
+	}
+
+	// the scheme is case insensitive
+	status, _ := request(t, auth, map[string]string{"Authorization": "bearer " + token})
+	if status != http.StatusOK {
+		t.Errorf("lowercase scheme status = %d, want 200", status)
+	}
+}
+
+func TestRequireGCP(t *testing.T) {
+	iss := newTestIssuer(t)
+	auth := newTestAuth(t, iss)
+
+	tests := []struct {
+		name   string
+		email  string
+		scopes []string
+	}{
+		{"exact principal", "scheduler@project.iam.gserviceaccount.com", []string{ScopeReconcileWrite}},
+		{"exact principal is case insensitive", "Scheduler@Project.iam.gserviceaccount.com", []string{ScopeReconcileWrite}},
+		{"domain principal", "someone@example.org", []string{ScopeReconcileRead}},
+		{"exact beats domain", "admin@example.org", []string{ScopeAPIKeysAdmin}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, got := request(t, auth, map[string]string{
+				"Authorization":  "Bearer " + iss.idToken(t, tt.email, true),
+				AuthMethodHeader: "gcp",
+			})
+			if status != http.StatusOK {
+				t.Fatalf("status = %d, want 200", status)
+			}
+			want := authorized{User: tt.email, Scopes: tt.scopes}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("authorized = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestRequireGCPRejected(t *testing.T) {
+	iss := newTestIssuer(t)
+	auth := newTestAuth(t, iss)
+
+	tests := map[string]string{
+		"email not verified": iss.idToken(t, "scheduler@project.iam.gserviceaccount.com", false),
+		"unknown principal":  iss.idToken(t, "intruder@elsewhere.example.com", true),
+		"lookalike domain":   iss.idToken(t, "someone@evilexample.org", true),
+		"missing email":      iss.idToken(t, "", true),
+		"wrong audience": iss.sign(t, map[string]interface{}{
+			"aud":            "https://elsewhere.example.org",
+			"email":          "scheduler@project.iam.gserviceaccount.com",
+			"email_verified": true,
+		}),
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			status, _ := request(t, auth, map[string]string{
+				"Authorization":  "Bearer " + token,
+				AuthMethodHeader: "gcp",
+			})
+			if status != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401", status)
+			}
+		})
+	}
+}
+
+// requestScoped runs one request through the middleware and RequireScope
+func requestScoped(t *testing.T, auth AuthMiddleware, headers map[string]string, scopes ...string) int {
+	t.Helper()
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, auth.Require, RequireScope(scopes...))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestRequireScope(t *testing.T) {
+	iss := newTestIssuer(t)
+	auth := newTestAuth(t, iss)
+
+	tests := []struct {
+		name     string
+		scope    string
+		required []string
+		status   int
+	}{
+		{"has scope", "reconcile:read reconcile:write", []string{ScopeReconcileWrite}, http.StatusOK},
+		{"has every scope", "reconcile:read reconcile:write", []string{ScopeReconcileRead, ScopeReconcileWrite}, http.StatusOK},
+		{"missing scope", "reconcile:read", []string{ScopeReconcileWrite}, http.StatusForbidden},
+		{"missing one of the scopes", "reconcile:read", []string{ScopeReconcileRead, ScopeReconcileWrite}, http.StatusForbidden},
+		// tokens issued before scopes existed
+		{"no scopes", "", []string{ScopeReconcileRead}, http.StatusForbidden},
+		{"scopes are exact", "reconcile:read:all", []string{ScopeReconcileRead}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := requestScoped(t, auth, map[string]string{
+				"Authorization": "Bearer " + iss.accessToken(t, testAudience, tt.scope),
+			}, tt.required...)
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestCheckScope(t *testing.T) {
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	setAuthorized(c, "client@clients", []string{ScopeMembersRead})
+
+	if err := CheckScope(c, ScopeMembersRead); err != nil {
+		t.Errorf("CheckScope(%s) = %v, want nil", ScopeMembersRead, err)
+	}
+	err := CheckScope(c, ScopeMembersDetails)
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusForbidden {
+		t.Errorf("CheckScope(%s) = %v, want a 403", ScopeMembersDetails, err)
+	}
+}
+
+func TestRequireGCPLegacyPrincipals(t *testing.T) {
+	iss := newTestIssuer(t)
+	// the old config was just a list of principals
+	auth, err := NewAuthMiddleware(iss.issuer(), testAudience, LegacyPrincipals([]string{"scheduler@project.iam.gserviceaccount.com"}))
+	if err != nil {
+		t.Fatalf("NewAuthMiddleware: %s", err)
+	}
+	auth.IDTokenValidator = iss.idTokenValidator
+	headers := map[string]string{
+		"Authorization":  "Bearer " + iss.idToken(t, "scheduler@project.iam.gserviceaccount.com", true),
+		AuthMethodHeader: "gcp",
+	}
+
+	status, got := request(t, auth, headers)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if want := []string{ScopeReconcileRead, ScopeReconcileWrite}; !reflect.DeepEqual(got.Scopes, want) {
+		t.Errorf("scopes = %v, want only %v", got.Scopes, want)
+	}
+	if status := requestScoped(t, auth, headers, ScopeReconcileWrite); status != http.StatusOK {
+		t.Errorf("reconcile status = %d, want 200", status)
+	}
+	for _, scope := range []string{ScopeAttendanceWrite, ScopeAPIKeysAdmin, ScopeMembersRead, ScopeMembersDetails} {
+		if status := requestScoped(t, auth, headers, scope); status != http.StatusForbidden {
+			t.Errorf("%s status = %d, want 403", scope, status)
+		}
+	}
+}
@@ -1,225 +1,199 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/gocarina/gocsv"
 	"github.com/labstack/echo/v4"
 	"github.com/theforgeinitiative/integrations/reconcile"
-	"github.com/theforgeinitiative/integrations/sfdc"
-	admin "google.golang.org/api/admin/directory/v1"
 )
 
-func (h *Handlers) Reconcile(c echo.Context) error {
+// dryRunParam defaults to a dry run. Dry runs only need read access, but
+// changing anything needs write.
+func dryRunParam(c echo.Context) (bool, error) {
 	dryRun := true
 	if param := c.QueryParam("dry_run"); len(param) > 0 {
 		var err error
 		dryRun, err = strconv.ParseBool(param)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "invalid format for dry_run param: %s")
+			return false, echo.NewHTTPError(http.StatusBadRequest, "invalid format for dry_run param")
 		}
 	}
-
-	var user string
-	if u := c.Get("authorized_user"); u != nil {
-		user = u.(string)
-	}
-
-	report := reconcile.Report{
-		Date:      time.Now(),
-		CheckMeIn: true,
-		User:      user,
-		Discord:   make(map[string]reconcile.Changes),
-		Groups:    make(map[string]reconcile.Changes),
-	}
-	respStatus := http.StatusOK
-
-	// get all current members from SFDC
-	contactList, err := h.SFClient.FindCurrentMembers()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to retrieve current members from sfdc", err)
-	}
-	contacts := contactEmailMap(contactList)
-	// add exceptions from config
-	h.addExceptions(contacts)
-
-	// CHECKMEIN
 	if !dryRun {
-		err = h.CheckMeInClient.BulkAdd(contactList)
+		err := CheckScope(c, ScopeReconcileWrite)
 		if err != nil {
-			c.Logger().Errorf("Failed to bulk add users to checkmein: %s", err)
-			respStatus = http.StatusMultiStatus
-			report.CheckMeIn = false
+			return false, err
 		}
 	}
+	return dryRun, nil
+}
 
-	// GOOGLE GROUPS
-
-	// get all members of Google Group
-	emailList, err := h.GroupsClient.ListMembers()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to retrieve group members").WithInternal(err)
-	}
-	emails := groupEmailMap(emailList)
-
-	var errored, add, del []string
-
-	// iterate over Salesforce data to find additions
-	for key, contact := range contacts {
-		if _, ok := emails[key]; !ok {
-			add = append(add, contact)
-			if !dryRun {
-				err := h.GroupsClient.AddMember(contact)
-				if err != nil {
-					c.Logger().Errorf("Failed to add %s to members group: %s", contact, err)
-					errored = append(errored, contact)
-					continue
-				}
-				c.Logger().Infof("Added %s to members group", contact)
-			}
+// reportFormat picks json or markdown from the format param or Accept header
+func reportFormat(c echo.Context) (string, error) {
+	format := strings.ToLower(c.QueryParam("format"))
+	if len(format) == 0 {
+		format = "json"
+		if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/markdown") {
+			format = "markdown"
 		}
 	}
+	if format != "json" && format != "markdown" {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "format must be json or markdown")
+	}
+	return format, nil
+}
 
-	// iterate over emails to find deletions
-	for key, member := range emails {
-		if _, ok := contacts[key]; !ok {
-			del = append(del, member)
-			if !dryRun {
-				err := h.GroupsClient.RemoveMember(member)
-				if err != nil {
-					c.Logger().Errorf("Failed to remove %s from members group: %s", member, err)
-					errored = append(errored, member)
-					continue
-				}
-				c.Logger().Infof("Removed %s from members group", member)
-			}
-		}
+func reportResponse(c echo.Context, format string, report reconcile.Report) error {
+	respStatus := http.StatusOK
+	if report.HasErrors() {
+		respStatus = http.StatusMultiStatus
 	}
-	report.Groups["members"] = reconcile.Changes{
-		Additions: add,
-		Deletions: del,
-		Errored:   errored,
+	if format == "json" {
+		return c.JSON(respStatus, report)
 	}
-	if len(errored) > 0 {
-		respStatus = http.StatusMultiStatus
+	body, err := report.RenderMarkdown()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to render report").WithInternal(err)
 	}
+	return c.Blob(respStatus, "text/markdown; charset=utf-8", body)
+}
 
-	// DISCORD
-	discAdd := make(map[string][]string)
-	discDel := make(map[string][]string)
-	discErrored := make(map[string][]string)
-	guildMembers, err := h.DiscordClient.GuildMembers()
+func (h *Handlers) Reconcile(c echo.Context) error {
+	format, err := reportFormat(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to retrieve discord guild members", err)
-	}
-	contactsByDiscord := discordContactMap(contactList)
-	for guild, members := range guildMembers {
-		discAdd[guild] = []string{}
-		discDel[guild] = []string{}
-		discErrored[guild] = []string{}
-
-		// Handle additions
-		for key := range contactsByDiscord {
-			m, ok := members[key]
-			if !ok || h.DiscordClient.HasMemberRole(m, guild) {
-				continue
-			}
-			discAdd[guild] = append(discAdd[guild], m.Nick())
-			if !dryRun {
-				err := h.DiscordClient.AddMemberRole(m.ID, guild)
-				if err != nil {
-					c.Logger().Errorf("Failed to add %s to %s discord member role: %s", m.Nick(), guild, err)
-					discErrored[guild] = append(discErrored[guild], m.Nick())
-					continue
-				}
-				c.Logger().Infof("Added %s to %s discord member role", m.Nick(), guild)
-			}
+		return err
+	}
+	dryRun, err := dryRunParam(c)
+	if err != nil {
+		return err
+	}
 
-		}
-		// Handle deletions
-		for _, m := range members {
-			_, ok := contactsByDiscord[m.ID]
-			if ok || !h.DiscordClient.HasMemberRole(m, guild) {
-				continue
-			}
-			discDel[guild] = append(discDel[guild], m.Nick())
-			if !dryRun {
-				err = h.DiscordClient.RemoveMemberRole(m.ID, guild)
-				if err != nil {
-					c.Logger().Errorf("Failed to remove %s from %s discord member role: %s", m.Nick(), guild, err)
-					errored = append(errored, m.Nick())
-					continue
-				}
-				c.Logger().Infof("Removed %s from %s discord member role", m.Nick(), guild)
-			}
-		}
+	var report reconcile.Report
+	if dryRun {
+		var result reconcile.Result
+		result, err = h.reconciler(c.Logger()).Run(true)
+		report = result.Report
+		report.User = AuthorizedUser(c)
+		report.Scopes = AuthorizedScopes(c)
+	} else {
+		report, err = h.RunReconcile(c.Logger(), AuthorizedUser(c), AuthorizedScopes(c))
 	}
-	for g := range h.DiscordClient.Guilds {
-		report.Discord[g] = reconcile.Changes{
-			Additions: discAdd[g],
-			Deletions: discDel[g],
-			Errored:   discErrored[g],
-		}
-		if len(discErrored[g]) > 0 {
-			respStatus = http.StatusMultiStatus
-		}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reconcile members").WithInternal(err)
 	}
+	return reportResponse(c, format, report)
+}
 
-	// set duration
-	report.Duration = time.Since(report.Date)
+// RunReconcile applies a full reconcile and sends the report if anything
+// changed. The scheduler uses it too.
+func (h *Handlers) RunReconcile(logger reconcile.Logger, user string, scopes []string) (reconcile.Report, error) {
+	result, err := h.reconciler(logger).Run(false)
+	if err != nil {
+		return reconcile.Report{}, err
+	}
+	report := result.Report
+	report.User = user
+	report.Scopes = scopes
 
 	// send report if changes were made
-	if !dryRun && report.HasChanges() {
+	if report.HasChanges() {
 		err = h.EmailClient.SendReconcileReport(report)
 		if err != nil {
-			c.Logger().Warnf("Failed to send reconciliation report: %s", err)
+			logger.Warnf("Failed to send reconciliation report: %s", err)
+		}
+		err = h.DiscordClient.PostAdminEmbed(report.DiscordEmbed())
+		if err != nil {
+			logger.Warnf("Failed to post reconciliation report to discord: %s", err)
 		}
 	}
-
-	return c.JSON(respStatus, report)
+	return report, nil
 }
 
-func (h *Handlers) addExceptions(emails map[string]string) {
-	for _, e := range h.GroupExceptions {
-		emails[groupKey(e)] = e
+// ReconcileContact fixes up one SFDC contact without touching anyone else.
+// Nothing is emailed, the report is only returned to the caller.
+func (h *Handlers) ReconcileContact(c echo.Context) error {
+	format, err := reportFormat(c)
+	if err != nil {
+		return err
 	}
+	dryRun, err := dryRunParam(c)
+	if err != nil {
+		return err
+	}
+
+	id := c.Param("id")
+	contacts, err := h.SFClient.FindContactsByIDs([]string{id})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to retrieve contact from sfdc").WithInternal(err)
+	}
+	if len(contacts) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "no contact with that id")
+	}
+
+	result, err := h.reconciler(c.Logger()).RunContacts(contacts, dryRun)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reconcile contact").WithInternal(err)
+	}
+	report := result.Report
+	report.User = AuthorizedUser(c)
+	report.Scopes = AuthorizedScopes(c)
+
+	if !dryRun {
+		c.Logger().Infof("%s reconciled contact %s", report.User, id)
+	}
+	return reportResponse(c, format, report)
 }
 
-func contactEmailMap(slice []sfdc.Contact) map[string]string {
-	contacts := make(map[string]string, len(slice))
-	for _, c := range slice {
-		if len(c.GroupEmail) > 0 {
-			contacts[groupKey(c.GroupEmail)] = c.GroupEmail
-		}
-		if len(c.GroupEmailAlt) > 0 {
-			contacts[groupKey(c.GroupEmailAlt)] = c.GroupEmailAlt
+// ReconcileDiff is a dry run returned as a download with one row per action,
+// as CSV or JSON depending on the format param or Accept header
+func (h *Handlers) ReconcileDiff(c echo.Context) error {
+	format := strings.ToLower(c.QueryParam("format"))
+	if len(format) == 0 {
+		format = "json"
+		if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/csv") {
+			format = "csv"
 		}
 	}
-	return contacts
-}
+	if format != "csv" && format != "json" {
+		return echo.NewHTTPError(http.StatusBadRequest, "format must be csv or json")
+	}
 
-// Gmail likes to "fix" missing dots and capitalization, so we'll normalize them to prevent trying to add duplicates
-func groupKey(email string) string {
-	key := strings.ToLower(email)
-	return strings.ReplaceAll(key, ".", "")
-}
+	result, err := h.reconciler(c.Logger()).Run(true)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reconcile members").WithInternal(err)
+	}
+	actions := result.Actions
+	if actions == nil {
+		actions = []reconcile.Action{}
+	}
 
-func groupEmailMap(slice []*admin.Member) map[string]string {
-	members := make(map[string]string, len(slice))
-	for _, m := range slice {
-		members[groupKey(m.Email)] = m.Email
+	filename := fmt.Sprintf("reconcile-diff-%s.%s", result.Report.Date.Format("20060102-150405"), format)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "json" {
+		return c.JSON(http.StatusOK, actions)
 	}
-	return members
+	body, err := gocsv.MarshalBytes(actions)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to write csv").WithInternal(err)
+	}
+	return c.Blob(http.StatusOK, "text/csv; charset=utf-8", body)
 }
 
-func discordContactMap(slice []sfdc.Contact) map[string]sfdc.Contact {
-	contacts := make(map[string]sfdc.Contact)
-	for _, c := range slice {
-		if len(c.DiscordID) > 0 {
-			contacts[c.DiscordID] = c
-		}
+func (h *Handlers) reconciler(logger reconcile.Logger) *reconcile.Reconciler {
+	return &reconcile.Reconciler{
+		SFClient:            h.SFClient,
+		GroupsClient:        h.GroupsClient,
+		GroupExceptions:     h.GroupExceptions,
+		CheckMeInExceptions: h.CheckMeInExceptions,
+		ManagerCampaign:     h.ManagerCampaign,
+		ManagerStatus:       h.ManagerStatus,
+		DiscordClient:       h.DiscordClient,
+		CheckMeInClient:     h.CheckMeInClient,
+		CheckMeInRoster:     h.CheckMeInRoster,
+		MQClient:            h.MQClient,
+		Logger:              logger,
 	}
-	return contacts
 }
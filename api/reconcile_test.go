@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gocarina/gocsv"
+	"github.com/labstack/echo/v4"
+	"github.com/theforgeinitiative/integrations/checkmein"
+	"github.com/theforgeinitiative/integrations/checkmein/checkmeintest"
+	"github.com/theforgeinitiative/integrations/discord"
+	"github.com/theforgeinitiative/integrations/googletest"
+	"github.com/theforgeinitiative/integrations/groups"
+	"github.com/theforgeinitiative/integrations/reconcile"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+const testMembersGroup = "members@example.org"
+
+// newDiffHandlers has one current member missing from CheckMeIn, and a
+// lapsed member in both CheckMeIn and the group
+func newDiffHandlers(t *testing.T) (*Handlers, *checkmeintest.Server, *googletest.Server) {
+	t.Helper()
+	sf, sfClient := newFakeSFDC(t)
+	jane := contactRecord("003000000000001", "100", "Jane D")
+	jane["Google_group__c"] = "jane@example.org"
+	jane["npo02__MembershipEndDate__c"] = "2099-01-31"
+	sf.records = []map[string]interface{}{jane}
+
+	google := googletest.NewServer()
+	t.Cleanup(google.Close)
+	google.SetMembers(testMembersGroup, []*admin.Member{
+		{Email: "jane@example.org"},
+		{Email: "lapsed@example.org"},
+	})
+	gc, err := groups.NewClient(testMembersGroup, google.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("failed to create groups client: %s", err)
+	}
+
+	cmi := checkmeintest.NewServer("admin", "secret")
+	t.Cleanup(cmi.Close)
+	cmi.SetMembers([]checkmein.BulkAddMember{
+		{Barcode: "300", DisplayName: "Lapsed", MembershipEndDate: "1/31/2099"},
+	})
+	cmiClient := checkmein.NewClient(cmi.URL, "admin", "secret")
+
+	return &Handlers{
+		SFClient:        sfClient,
+		GroupsClient:    &gc,
+		CheckMeInClient: &cmiClient,
+		DiscordClient:   &discord.Client{},
+	}, cmi, google
+}
+
+func diffRequest(t *testing.T, h *Handlers, query, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/reconcile/diff"+query, nil)
+	if len(accept) > 0 {
+		req.Header.Set(echo.HeaderAccept, accept)
+	}
+	rec := httptest.NewRecorder()
+	err := h.ReconcileDiff(echo.New().NewContext(req, rec))
+	if err != nil {
+		t.Fatalf("ReconcileDiff: %s", err)
+	}
+	return rec
+}
+
+func checkDiff(t *testing.T, actions []reconcile.Action) {
+	t.Helper()
+	want := []struct{ target, action, identifier, contactID string }{
+		{reconcile.TargetCheckMeIn, reconcile.ActionAdd, "100", "003000000000001"},
+		{reconcile.TargetCheckMeIn, reconcile.ActionDelete, "300", ""},
+		{"groups:members", reconcile.ActionDelete, "lapsed@example.org", ""},
+	}
+	if len(actions) != len(want) {
+		t.Fatalf("actions = %+v, want %d", actions, len(want))
+	}
+	for i, w := range want {
+		a := actions[i]
+		if a.Target != w.target || a.Action != w.action || a.Identifier != w.identifier || a.ContactID != w.contactID {
+			t.Errorf("action %d = %+v, want %+v", i, a, w)
+		}
+	}
+	if actions[0].DisplayName != "Jane D" || actions[0].EndDate != "2099-01-31" || actions[0].MembershipStatus != "Current" {
+		t.Errorf("added action is missing contact details: %+v", actions[0])
+	}
+	if !strings.HasPrefix(actions[1].Reason, "not a current member") {
+		t.Errorf("delete reason = %q", actions[1].Reason)
+	}
+}
+
+func TestReconcileDiffJSON(t *testing.T) {
+	h, cmi, google := newDiffHandlers(t)
+	rec := diffRequest(t, h, "", "")
+
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMEApplicationJSON) {
+		t.Errorf("content type = %q", ct)
+	}
+	if cd := rec.Header().Get(echo.HeaderContentDisposition); !strings.Contains(cd, `filename="reconcile-diff-`) || !strings.HasSuffix(cd, `.json"`) {
+		t.Errorf("content disposition = %q", cd)
+	}
+	var actions []reconcile.Action
+	err := json.Unmarshal(rec.Body.Bytes(), &actions)
+	if err != nil {
+		t.Fatalf("invalid json %q: %s", rec.Body.String(), err)
+	}
+	checkDiff(t, actions)
+
+	// it's always a dry run
+	if len(cmi.Uploads()) != 0 || len(google.Members(testMembersGroup)) != 2 {
+		t.Error("diff changed checkmein or the group")
+	}
+}
+
+func TestReconcileDiffCSV(t *testing.T) {
+	for name, req := range map[string]struct{ query, accept string }{
+		"format param":  {"?format=CSV", ""},
+		"accept header": {"", "text/csv"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h, _, _ := newDiffHandlers(t)
+			rec := diffRequest(t, h, req.query, req.accept)
+
+			if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/csv; charset=utf-8" {
+				t.Errorf("content type = %q", ct)
+			}
+			if cd := rec.Header().Get(echo.HeaderContentDisposition); !strings.HasSuffix(cd, `.csv"`) {
+				t.Errorf("content disposition = %q", cd)
+			}
+			header, _, _ := strings.Cut(rec.Body.String(), "\n")
+			if header != "target,action,identifier,contact_id,display_name,membership_status,end_date,reason" {
+				t.Errorf("header = %q", header)
+			}
+			var actions []reconcile.Action
+			err := gocsv.Unmarshal(bytes.NewReader(rec.Body.Bytes()), &actions)
+			if err != nil {
+				t.Fatalf("invalid csv %q: %s", rec.Body.String(), err)
+			}
+			checkDiff(t, actions)
+		})
+	}
+}
+
+func TestReconcileDiffEmpty(t *testing.T) {
+	h, cmi, google := newDiffHandlers(t)
+	cmi.SetMembers([]checkmein.BulkAddMember{{Barcode: "100", DisplayName: "Jane D", MembershipEndDate: "1/31/2099"}})
+	google.SetMembers(testMembersGroup, []*admin.Member{{Email: "jane@example.org"}})
+
+	rec := diffRequest(t, h, "", "")
+	// an empty list, not null
+	if body := strings.TrimSpace(rec.Body.String()); body != "[]" {
+		t.Errorf("body = %q, want []", body)
+	}
+}
+
+func TestReconcileDiffBadFormat(t *testing.T) {
+	h, _, _ := newDiffHandlers(t)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/reconcile/diff?format=xml", nil)
+	err := h.ReconcileDiff(echo.New().NewContext(req, httptest.NewRecorder()))
+	if code := httpCode(t, err); code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", code)
+	}
+}
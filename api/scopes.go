@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	ScopeReconcileRead   = "reconcile:read"
+	ScopeReconcileWrite  = "reconcile:write"
+	ScopeAttendanceWrite = "attendance:write"
+	ScopeAPIKeysAdmin    = "apikeys:admin"
+	ScopeMembersRead     = "members:read"
+	// adds membership and waiver dates to member lookups
+	ScopeMembersDetails = "members:details"
+)
+
+// AllScopes is granted to every request when auth is disabled
+var AllScopes = []string{
+	ScopeReconcileRead,
+	ScopeReconcileWrite,
+	ScopeAttendanceWrite,
+	ScopeAPIKeysAdmin,
+	ScopeMembersRead,
+	ScopeMembersDetails,
+}
+
+// LegacyPrincipalScopes are granted to GCP principals configured as a plain
+// list, the format from before per-principal scopes when they could only reconcile
+var LegacyPrincipalScopes = []string{ScopeReconcileRead, ScopeReconcileWrite}
+
+// LegacyPrincipals converts the old list of principals to principal scopes
+func LegacyPrincipals(principals []string) map[string][]string {
+	scopes := make(map[string][]string, len(principals))
+	for _, p := range principals {
+		scopes[p] = LegacyPrincipalScopes
+	}
+	return scopes
+}
+
+const (
+	userContextKey   = "authorized_user"
+	scopesContextKey = "authorized_scopes"
+)
+
+func setAuthorized(c echo.Context, user string, scopes []string) {
+	scopes = append([]string{}, scopes...)
+	sort.Strings(scopes)
+	c.Set(userContextKey, user)
+	c.Set(scopesContextKey, scopes)
+	c.Logger().Infof("Authorized %s with scopes [%s]", user, strings.Join(scopes, " "))
+}
+
+// AuthorizedUser is the token subject or GCP principal, empty if unauthenticated
+func AuthorizedUser(c echo.Context) string {
+	user, _ := c.Get(userContextKey).(string)
+	return user
+}
+
+// AuthorizedScopes are the scopes granted to the current request, sorted
+func AuthorizedScopes(c echo.Context) []string {
+	scopes, _ := c.Get(scopesContextKey).([]string)
+	return scopes
+}
+
+func HasScope(c echo.Context, scope string) bool {
+	for _, s := range AuthorizedScopes(c) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckScope returns a 403 if the request wasn't granted scope
+func CheckScope(c echo.Context, scope string) error {
+	if HasScope(c, scope) {
+		return nil
+	}
+	c.Logger().Infof("%s is missing required scope %s", AuthorizedUser(c), scope)
+	return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("missing required scope: %s", scope))
+}
+
+// RequireScope rejects requests that weren't granted all of scopes. It must run
+// after an auth middleware.
+func RequireScope(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, s := range scopes {
+				err := CheckScope(c, s)
+				if err != nil {
+					return err
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// AllowAll stands in for auth when it's disabled, granting every scope
+func AllowAll(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set(userContextKey, "anonymous")
+		c.Set(scopesContextKey, AllScopes)
+		return next(c)
+	}
+}
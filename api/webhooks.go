@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+// Salesforce batches up to 100 notifications per message, which is well under this
+const maxWebhookBody = 1 << 20
+
+// Webhook routes. Salesforce can't add headers to outbound messages, so their
+// token is in the query string and the path mustn't be access logged.
+const (
+	SFDCOutboundPath = "/api/v1/sfdc/outbound"
+	SFDCEventsPath   = "/api/v1/sfdc/events"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a platform event callout body
+const SignatureHeader = "X-Signature"
+
+type SFDCWebhookConfig struct {
+	// outbound messages are only accepted from this org
+	OrganizationID string `mapstructure:"organizationId"`
+	// shared secret in the outbound message endpoint URL, since SOAP messages aren't signed
+	Token string `mapstructure:"token"`
+	// HMAC key for platform event callouts
+	Secret string `mapstructure:"secret"`
+}
+
+// platformEvents is what the platform event flow posts. Either ID may be empty.
+type platformEvents struct {
+	Events []struct {
+		ContactID string `json:"contactId"`
+		AccountID string `json:"accountId"`
+	} `json:"events"`
+}
+
+// SFDCOutboundMessage reconciles the contacts and accounts in a Salesforce
+// outbound message. Anything but an ack makes Salesforce retry later.
+func (h *Handlers) SFDCOutboundMessage(c echo.Context) error {
+	token := c.QueryParam("token")
+	if len(h.SFDCWebhook.Token) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(h.SFDCWebhook.Token)) != 1 {
+		// the access log skips this path, so note who's knocking
+		c.Logger().Warnf("Rejected salesforce outbound message from %s: invalid token", c.RealIP())
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+	}
+	msg, err := sfdc.ParseOutboundMessage(io.LimitReader(c.Request().Body, maxWebhookBody))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid outbound message").WithInternal(err)
+	}
+	if msg.OrganizationID != h.SFDCWebhook.OrganizationID {
+		return echo.NewHTTPError(http.StatusForbidden, "unexpected organization").WithInternal(fmt.Errorf("outbound message from org %s", msg.OrganizationID))
+	}
+
+	err = h.syncContacts(c, msg.ContactIDs, msg.AccountIDs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reconcile contacts").WithInternal(err)
+	}
+	return c.Blob(http.StatusOK, "text/xml; charset=utf-8", []byte(sfdc.OutboundAck))
+}
+
+// SFDCPlatformEvents reconciles the contacts and accounts named in a signed
+// platform event callout
+func (h *Handlers) SFDCPlatformEvents(c echo.Context) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxWebhookBody))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to read body").WithInternal(err)
+	}
+	if !validSignature(h.SFDCWebhook.Secret, body, c.Request().Header.Get(SignatureHeader)) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid signature")
+	}
+	var events platformEvents
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&events)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid platform events").WithInternal(err)
+	}
+
+	var contactIDs, accountIDs []string
+	for _, e := range events.Events {
+		if len(e.ContactID) > 0 {
+			contactIDs = append(contactIDs, e.ContactID)
+		}
+		if len(e.AccountID) > 0 {
+			accountIDs = append(accountIDs, e.AccountID)
+		}
+	}
+	if len(contactIDs) == 0 && len(accountIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "no contact or account ids in events")
+	}
+
+	err = h.syncContacts(c, contactIDs, accountIDs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reconcile contacts").WithInternal(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func validSignature(secret string, body []byte, signature string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// syncContacts reconciles the given contacts plus everyone in the given
+// accounts. Partial failures are returned so the sender retries.
+func (h *Handlers) syncContacts(c echo.Context, contactIDs, accountIDs []string) error {
+	byID, err := h.SFClient.FindContactsByIDs(unique(contactIDs))
+	if err != nil {
+		return err
+	}
+	byAccount, err := h.SFClient.FindContactsByAccountIDs(unique(accountIDs))
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	var contacts []sfdc.Contact
+	for _, contact := range append(byID, byAccount...) {
+		if seen[contact.ID] {
+			continue
+		}
+		seen[contact.ID] = true
+		contacts = append(contacts, contact)
+	}
+	if len(contacts) == 0 {
+		c.Logger().Warnf("No contacts found for contacts [%s] and accounts [%s]", strings.Join(contactIDs, " "), strings.Join(accountIDs, " "))
+		return nil
+	}
+
+	result, err := h.reconciler(c.Logger()).RunContacts(contacts, false)
+	if err != nil {
+		return err
+	}
+	c.Logger().Infof("Reconciled %d contacts from salesforce in %s", len(contacts), result.Report.Duration)
+	if result.Report.HasErrors() {
+		return fmt.Errorf("some changes failed for %d contacts", len(contacts))
+	}
+	return nil
+}
+
+func unique(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/simpleforce/simpleforce"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+const (
+	testOrgID         = "00D000000000001"
+	testWebhookToken  = "outbound-token"
+	testWebhookSecret = "events-secret"
+)
+
+const testOutboundMessage = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+ <soapenv:Body>
+  <notifications xmlns="http://soap.sforce.com/2005/09/outbound">
+   <OrganizationId>ORG_ID</OrganizationId>
+   <ActionId>04k000000000001</ActionId>
+   <Notification>
+    <Id>04l000000000001</Id>
+    <sObject xsi:type="sf:Contact" xmlns:sf="urn:sobject.enterprise.soap.sforce.com">
+     <sf:Id>003000000000001</sf:Id>
+    </sObject>
+   </Notification>
+  </notifications>
+ </soapenv:Body>
+</soapenv:Envelope>`
+
+// fakeSFDC answers every SOQL query with its records and remembers the
+// queries and contact updates
+type fakeSFDC struct {
+	*httptest.Server
+	mu      sync.Mutex
+	records []map[string]interface{}
+	queries []string
+	updates map[string]map[string]interface{}
+}
+
+func newFakeSFDC(t *testing.T) (*fakeSFDC, *sfdc.Client) {
+	t.Helper()
+	f := &fakeSFDC{updates: make(map[string]map[string]interface{})}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/query"):
+			f.queries = append(f.queries, r.URL.Query().Get("q"))
+			records := f.records
+			if records == nil {
+				records = []map[string]interface{}{}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"totalSize": len(records), "done": true, "records": records})
+		case r.Method == http.MethodPatch && strings.Contains(r.URL.Path, "/sobjects/Contact/"):
+			var update map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&update)
+			f.updates[path.Base(r.URL.Path)] = update
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.Close)
+
+	sfc := simpleforce.NewClient(f.URL, "client", simpleforce.DefaultAPIVersion)
+	sfc.SetSidLoc("session", f.URL)
+	return f, &sfdc.Client{SFClient: sfc}
+}
+
+func (f *fakeSFDC) Queries() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.queries...)
+}
+
+func (f *fakeSFDC) Updates() map[string]map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	updates := make(map[string]map[string]interface{}, len(f.updates))
+	for id, u := range f.updates {
+		updates[id] = u
+	}
+	return updates
+}
+
+func newWebhookHandlers(t *testing.T) (*Handlers, *fakeSFDC) {
+	f, sfClient := newFakeSFDC(t)
+	return &Handlers{
+		SFClient: sfClient,
+		SFDCWebhook: SFDCWebhookConfig{
+			OrganizationID: testOrgID,
+			Token:          testWebhookToken,
+			Secret:         testWebhookSecret,
+		},
+	}, f
+}
+
+func httpCode(t *testing.T, err error) int {
+	t.Helper()
+	var he *echo.HTTPError
+	if !errors.As(err, &he) {
+		t.Fatalf("expected an echo.HTTPError, got %v", err)
+	}
+	return he.Code
+}
+
+func TestSFDCOutboundMessage(t *testing.T) {
+	h, f := newWebhookHandlers(t)
+	body := strings.Replace(testOutboundMessage, "ORG_ID", testOrgID, 1)
+	req := httptest.NewRequest(http.MethodPost, SFDCOutboundPath+"?token="+testWebhookToken, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	err := h.SFDCOutboundMessage(echo.New().NewContext(req, rec))
+	if err != nil {
+		t.Fatalf("SFDCOutboundMessage: %s", err)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != sfdc.OutboundAck {
+		t.Errorf("response = %d %q, want an ack", rec.Code, rec.Body.String())
+	}
+	queries := f.Queries()
+	if len(queries) != 1 || !strings.Contains(queries[0], "Id IN ('003000000000001')") {
+		t.Errorf("queries = %q, want a lookup of the contact", queries)
+	}
+}
+
+func TestSFDCOutboundMessageRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		org   string
+		body  string
+		want  int
+	}{
+		{"missing token", "", testOrgID, "", http.StatusUnauthorized},
+		{"wrong token", "?token=guess", testOrgID, "", http.StatusUnauthorized},
+		{"wrong org", "?token=" + testWebhookToken, "00D000000000999", "", http.StatusForbidden},
+		{"not soap", "?token=" + testWebhookToken, "", "<html></html>", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, f := newWebhookHandlers(t)
+			body := tt.body
+			if len(body) == 0 {
+				body = strings.Replace(testOutboundMessage, "ORG_ID", tt.org, 1)
+			}
+			req := httptest.NewRequest(http.MethodPost, SFDCOutboundPath+tt.query, strings.NewReader(body))
+
+			err := h.SFDCOutboundMessage(echo.New().NewContext(req, httptest.NewRecorder()))
+			if code := httpCode(t, err); code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+			if len(f.Queries()) > 0 {
+				t.Error("rejected message reached salesforce")
+			}
+		})
+	}
+}
+
+func TestSFDCOutboundMessageWithoutConfiguredToken(t *testing.T) {
+	h, _ := newWebhookHandlers(t)
+	h.SFDCWebhook.Token = ""
+	body := strings.Replace(testOutboundMessage, "ORG_ID", testOrgID, 1)
+	req := httptest.NewRequest(http.MethodPost, SFDCOutboundPath+"?token=", strings.NewReader(body))
+
+	err := h.SFDCOutboundMessage(echo.New().NewContext(req, httptest.NewRecorder()))
+	if code := httpCode(t, err); code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSFDCPlatformEvents(t *testing.T) {
+	h, f := newWebhookHandlers(t)
+	body := `{"events":[{"contactId":"003000000000001"},{"accountId":"001000000000001"}]}`
+	req := httptest.NewRequest(http.MethodPost, SFDCEventsPath, strings.NewReader(body))
+	req.Header.Set(SignatureHeader, sign(testWebhookSecret, body))
+	rec := httptest.NewRecorder()
+
+	err := h.SFDCPlatformEvents(echo.New().NewContext(req, rec))
+	if err != nil {
+		t.Fatalf("SFDCPlatformEvents: %s", err)
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	queries := strings.Join(f.Queries(), "\n")
+	if !strings.Contains(queries, "Id IN ('003000000000001')") || !strings.Contains(queries, "AccountId IN ('001000000000001')") {
+		t.Errorf("queries = %q, want lookups of the contact and account", queries)
+	}
+}
+
+func TestSFDCPlatformEventsRejected(t *testing.T) {
+	body := `{"events":[{"contactId":"003000000000001"}]}`
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		want      int
+	}{
+		{"missing signature", body, "", http.StatusUnauthorized},
+		{"wrong secret", body, sign("guess", body), http.StatusUnauthorized},
+		{"tampered body", strings.Replace(body, "001", "002", 1), sign(testWebhookSecret, body), http.StatusUnauthorized},
+		{"not hex", body, "sha256=zz", http.StatusUnauthorized},
+		{"no ids", `{"events":[{}]}`, sign(testWebhookSecret, `{"events":[{}]}`), http.StatusBadRequest},
+		{"not json", "nope", sign(testWebhookSecret, "nope"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, f := newWebhookHandlers(t)
+			req := httptest.NewRequest(http.MethodPost, SFDCEventsPath, strings.NewReader(tt.body))
+			req.Header.Set(SignatureHeader, tt.signature)
+
+			err := h.SFDCPlatformEvents(echo.New().NewContext(req, httptest.NewRecorder()))
+			if code := httpCode(t, err); code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+			if len(f.Queries()) > 0 {
+				t.Error("rejected event reached salesforce")
+			}
+		})
+	}
+}
+
+func TestValidSignatureWithoutSecret(t *testing.T) {
+	if validSignature("", []byte("body"), sign("", "body")) {
+		t.Error("an empty secret must never validate")
+	}
+}
@@ -0,0 +1,185 @@
+// Package checkmeintest provides a stand-in CheckMeIn server for exercising
+// the checkmein client without a live instance.
+package checkmeintest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+
+	"github.com/gocarina/gocsv"
+	"github.com/theforgeinitiative/integrations/checkmein"
+)
+
+const sessionCookie = "session_id"
+
+type Server struct {
+	*httptest.Server
+	Username string
+	Password string
+
+	mu            sync.Mutex
+	sessions      map[string]bool
+	loginAttempts int
+	uploads       [][]checkmein.BulkAddMember
+	members       map[string]checkmein.BulkAddMember
+	visits        []checkmein.Visit
+}
+
+// NewServer starts a server accepting the given admin credentials. Close it when done.
+func NewServer(username, password string) *Server {
+	s := &Server{
+		Username: username,
+		Password: password,
+		sessions: make(map[string]bool),
+		members:  make(map[string]checkmein.BulkAddMember),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/profile/loginAttempt", s.loginAttempt)
+	mux.HandleFunc("/profile/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>Please log in</html>"))
+	})
+	mux.HandleFunc("/admin", s.requireSession(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>Admin</html>"))
+	}))
+	mux.HandleFunc("/admin/bulkAddMembers", s.requireSession(s.bulkAddMembers))
+	mux.HandleFunc("/admin/exportMembers", s.requireSession(s.exportMembers))
+	mux.HandleFunc("/reports/exportVisits", s.requireSession(s.exportVisits))
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// Uploads returns the rows received by each bulk add call, in order
+func (s *Server) Uploads() [][]checkmein.BulkAddMember {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]checkmein.BulkAddMember(nil), s.uploads...)
+}
+
+// Members returns the current roster sorted by barcode
+func (s *Server) Members() []checkmein.BulkAddMember {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := make([]checkmein.BulkAddMember, 0, len(s.members))
+	for _, m := range s.members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Barcode < members[j].Barcode })
+	return members
+}
+
+// SetMembers seeds the roster returned by the member export
+func (s *Server) SetMembers(members []checkmein.BulkAddMember) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members = make(map[string]checkmein.BulkAddMember, len(members))
+	for _, m := range members {
+		s.members[m.Barcode] = m
+	}
+}
+
+// SetVisits seeds the visit export. The date range isn't filtered.
+func (s *Server) SetVisits(visits []checkmein.Visit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.visits = visits
+}
+
+func (s *Server) LoginAttempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loginAttempts
+}
+
+// ExpireSessions invalidates every session, as if CheckMeIn restarted
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]bool)
+}
+
+func (s *Server) loginAttempt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	s.loginAttempts++
+	s.mu.Unlock()
+
+	if r.PostFormValue("username") != s.Username || r.PostFormValue("password") != s.Password {
+		http.Redirect(w, r, "/profile/login?error=1", http.StatusSeeOther)
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	s.mu.Lock()
+	s.sessions[id] = true
+	s.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/"})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (s *Server) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookie)
+		s.mu.Lock()
+		ok := err == nil && s.sessions[cookie.Value]
+		s.mu.Unlock()
+		if !ok {
+			http.Redirect(w, r, "/profile/login", http.StatusSeeOther)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) bulkAddMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, _, err := r.FormFile("csvfile")
+	if err != nil {
+		http.Error(w, "missing csvfile", http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+
+	var rows []checkmein.BulkAddMember
+	err = gocsv.Unmarshal(f, &rows)
+	if err != nil {
+		http.Error(w, "invalid csv: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads = append(s.uploads, rows)
+	for _, row := range rows {
+		s.members[row.Barcode] = row
+	}
+	w.Write([]byte("<html>Members added</html>"))
+}
+
+func (s *Server) exportMembers(w http.ResponseWriter, r *http.Request) {
+	members := s.Members()
+	w.Header().Set("Content-Type", "text/csv")
+	gocsv.Marshal(members, w)
+}
+
+func (s *Server) exportVisits(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	visits := append([]checkmein.Visit(nil), s.visits...)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/csv")
+	gocsv.Marshal(visits, w)
+}
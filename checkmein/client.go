@@ -6,20 +6,28 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/cookiejar"
-	"net/url"
+	"sync"
 	"time"
 
 	"github.com/gocarina/gocsv"
+	"github.com/theforgeinitiative/integrations/metrics"
 	"github.com/theforgeinitiative/integrations/sfdc"
 )
 
 const BulkAddDateFormat = "1/2/2006"
 
 type Client struct {
-	URL        string
-	Username   string
-	Password   string
-	httpClient *http.Client
+	URL      string
+	Username string
+	Password string
+	// default to DefaultMembersExportPath and DefaultVisitsExportPath
+	MembersExportPath string
+	VisitsExportPath  string
+	httpClient        *http.Client
+	// serializes logins so concurrent callers share one session
+	sessionMu sync.Mutex
+	// bumped on every login, so a request can tell if its session was already replaced
+	sessionGen uint64
 }
 
 type BulkAddMember struct {
@@ -35,63 +43,93 @@ func NewClient(url, username, password string) Client {
 	jar, _ := cookiejar.New(nil)
 
 	return Client{
-		URL:      url,
-		Username: username,
-		Password: password,
+		URL:               url,
+		Username:          username,
+		Password:          password,
+		MembersExportPath: DefaultMembersExportPath,
+		VisitsExportPath:  DefaultVisitsExportPath,
 		httpClient: &http.Client{
-			Jar: jar,
+			Jar:           jar,
+			CheckRedirect: stopAtLogin,
 		},
 	}
 }
 
-func (c *Client) BulkAdd(contacts []sfdc.Contact) error {
-	err := c.authenticate()
+// DefaultMembersExportPath is where we expect the roster export, in the same
+// columns bulk add accepts. CheckMeIn doesn't document an export API and this
+// route hasn't been confirmed against a live instance (the original client
+// only ever called bulkAddMembers), so set checkmein.membersExportPath to
+// whatever the deployed instance serves. While the export fails, reconcile
+// falls back to bulk adding every current member.
+const DefaultMembersExportPath = "/admin/exportMembers"
+
+func MemberFromContact(c sfdc.Contact) (BulkAddMember, error) {
+	endDate, err := time.Parse(sfdc.DateFormat, c.MembershipEndDate)
 	if err != nil {
-		return fmt.Errorf("failed to authenticate to checkmein: %w", err)
+		return BulkAddMember{}, fmt.Errorf("failed to parse membership end date for %s: %w", c.DisplayName, err)
+	}
+	return BulkAddMember{
+		Barcode:           c.Barcode,
+		DisplayName:       c.DisplayName,
+		FirstName:         c.FirstName,
+		LastName:          c.LastName,
+		MembershipEndDate: endDate.Format(BulkAddDateFormat),
+		Email:             c.Email,
+	}, nil
+}
+
+// EndDate parses the membership end date in either the CheckMeIn or SFDC format
+func (m BulkAddMember) EndDate() (time.Time, error) {
+	t, err := time.Parse(BulkAddDateFormat, m.MembershipEndDate)
+	if err == nil {
+		return t, nil
 	}
+	return time.Parse(sfdc.DateFormat, m.MembershipEndDate)
+}
 
-	// Build CSV file
+func (c *Client) BulkAdd(contacts []sfdc.Contact) error {
 	var rows []BulkAddMember
 	for _, c := range contacts {
-		endDate, err := time.Parse(sfdc.DateFormat, c.MembershipEndDate)
+		row, err := MemberFromContact(c)
 		if err != nil {
-			return fmt.Errorf("failed to parse membership end date for %s: %w", c.DisplayName, err)
+			return err
 		}
-		rows = append(rows, BulkAddMember{
-			Barcode:           c.Barcode,
-			DisplayName:       c.DisplayName,
-			FirstName:         c.FirstName,
-			LastName:          c.LastName,
-			MembershipEndDate: endDate.Format(BulkAddDateFormat),
-			Email:             c.Email,
-		})
+		rows = append(rows, row)
 	}
+	return c.Upload(rows)
+}
 
+// Upload sends rows to the bulk add endpoint. CheckMeIn updates existing
+// members by barcode, so this is also how end dates get changed.
+func (c *Client) Upload(rows []BulkAddMember) error {
 	csvContent, err := gocsv.MarshalBytes(rows)
 	if err != nil {
 		return fmt.Errorf("failed to generate CSV: %w", err)
 	}
 
-	// generate multipart form
-	var b bytes.Buffer
-	w := multipart.NewWriter(&b)
-	fw, err := w.CreateFormFile("csvfile", "report.csv")
-	if err != nil {
-		return fmt.Errorf("failed to write csv to upload form: %w", err)
-	}
-	_, err = fw.Write(csvContent)
-	if err != nil {
-		return fmt.Errorf("failed to buffer CSV: %w", err)
-	}
-	w.Close()
-
-	req, err := http.NewRequest("POST", c.URL+"/admin/bulkAddMembers", &b)
-	if err != nil {
-		return fmt.Errorf("failed to build request for bulk add: %w", err)
-	}
-	req.Header.Set("Content-Type", w.FormDataContentType())
+	// the form is rebuilt if we have to retry after logging in again
+	done := metrics.Track("checkmein", "upload")
+	resp, err := c.do(func() (*http.Request, error) {
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		fw, err := w.CreateFormFile("csvfile", "report.csv")
+		if err != nil {
+			return nil, fmt.Errorf("failed to write csv to upload form: %w", err)
+		}
+		_, err = fw.Write(csvContent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to buffer CSV: %w", err)
+		}
+		w.Close()
 
-	resp, err := c.httpClient.Do(req)
+		req, err := http.NewRequest("POST", c.URL+"/admin/bulkAddMembers", &b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build request for bulk add: %w", err)
+		}
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return req, nil
+	})
+	done(statusError(resp, err))
 	if err != nil {
 		return fmt.Errorf("failed to bulk add to checkmein: %w", err)
 	}
@@ -103,32 +141,25 @@ func (c *Client) BulkAdd(contacts []sfdc.Contact) error {
 	return nil
 }
 
-func (c *Client) authenticate() error {
-	// check if session cookie is still valid
-	url, _ := url.Parse(c.URL)
-	for _, cookie := range c.httpClient.Jar.Cookies(url) {
-		if cookie.Name == "session_id" && cookie.Valid() == nil {
-			return nil
-		}
-	}
-
-	req, err := http.NewRequest("GET", c.URL+"/profile/loginAttempt", nil)
+// ListMembers returns every member CheckMeIn knows about, including expired ones
+func (c *Client) ListMembers() ([]BulkAddMember, error) {
+	done := metrics.Track("checkmein", "list_members")
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest("GET", c.URL+c.MembersExportPath, nil)
+	})
+	done(statusError(resp, err))
 	if err != nil {
-		return fmt.Errorf("failed to build login request: %w", err)
-	}
-	q := req.URL.Query()
-	q.Add("username", c.Username)
-	q.Add("password", c.Password)
-	req.URL.RawQuery = q.Encode()
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to login to checkmein: %w", err)
+		return nil, fmt.Errorf("failed to export checkmein members: %w", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received bad status from checkmein: %d", resp.StatusCode)
+		return nil, fmt.Errorf("received bad status from checkmein: %d", resp.StatusCode)
 	}
 
-	return nil
+	var members []BulkAddMember
+	err = gocsv.Unmarshal(resp.Body, &members)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse member export: %w", err)
+	}
+	return members, nil
 }
@@ -0,0 +1,107 @@
+package checkmein_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/theforgeinitiative/integrations/checkmein"
+	"github.com/theforgeinitiative/integrations/checkmein/checkmeintest"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+func testContact(barcode, endDate string) sfdc.Contact {
+	return sfdc.Contact{
+		Barcode:           barcode,
+		DisplayName:       "Ada L",
+		FirstName:         "Ada",
+		LastName:          "Lovelace",
+		Email:             "ada@example.com",
+		MembershipEndDate: endDate,
+	}
+}
+
+func TestMemberFromContactDates(t *testing.T) {
+	tests := []struct {
+		endDate string
+		want    string
+	}{
+		{"2024-03-05", "3/5/2024"},
+		{"2024-12-31", "12/31/2024"},
+		{"2025-01-01", "1/1/2025"},
+	}
+	for _, tt := range tests {
+		row, err := checkmein.MemberFromContact(testContact("100", tt.endDate))
+		if err != nil {
+			t.Errorf("MemberFromContact(%s): %s", tt.endDate, err)
+			continue
+		}
+		if row.MembershipEndDate != tt.want {
+			t.Errorf("end date for %s = %q, want %q", tt.endDate, row.MembershipEndDate, tt.want)
+		}
+		// whatever we send has to parse back to the same day
+		end, err := row.EndDate()
+		if err != nil || end.Format(sfdc.DateFormat) != tt.endDate {
+			t.Errorf("EndDate() for %s = %s, %v", tt.endDate, end, err)
+		}
+	}
+}
+
+func TestMemberFromContactBadEndDate(t *testing.T) {
+	for _, endDate := range []string{"", "03/05/2024", "not a date"} {
+		_, err := checkmein.MemberFromContact(testContact("100", endDate))
+		if err == nil {
+			t.Errorf("MemberFromContact(%q) should fail", endDate)
+		}
+	}
+}
+
+func TestUpload(t *testing.T) {
+	srv := checkmeintest.NewServer("admin", "secret")
+	defer srv.Close()
+	c := checkmein.NewClient(srv.URL, "admin", "secret")
+
+	var rows []checkmein.BulkAddMember
+	for _, contact := range []sfdc.Contact{testContact("100", "2024-03-05"), testContact("200", "2025-11-30")} {
+		row, err := checkmein.MemberFromContact(contact)
+		if err != nil {
+			t.Fatalf("MemberFromContact: %s", err)
+		}
+		rows = append(rows, row)
+	}
+
+	err := c.Upload(rows)
+	if err != nil {
+		t.Fatalf("Upload: %s", err)
+	}
+
+	uploads := srv.Uploads()
+	if len(uploads) != 1 {
+		t.Fatalf("got %d uploads, want 1", len(uploads))
+	}
+	if !reflect.DeepEqual(uploads[0], rows) {
+		t.Errorf("uploaded rows = %+v, want %+v", uploads[0], rows)
+	}
+	if got := srv.Members(); !reflect.DeepEqual(got, rows) {
+		t.Errorf("roster = %+v, want %+v", got, rows)
+	}
+}
+
+func TestBulkAddBadEndDate(t *testing.T) {
+	srv := checkmeintest.NewServer("admin", "secret")
+	defer srv.Close()
+	c := checkmein.NewClient(srv.URL, "admin", "secret")
+
+	contacts := []sfdc.Contact{
+		testContact("100", "2024-03-05"),
+		testContact("200", "someday"),
+		testContact("300", "2024-04-01"),
+	}
+	err := c.BulkAdd(contacts)
+	if err == nil {
+		t.Fatal("expected an error for the bad end date")
+	}
+	// nothing is sent rather than a partial roster
+	if got := len(srv.Uploads()); got != 0 {
+		t.Errorf("got %d uploads, want none", got)
+	}
+}
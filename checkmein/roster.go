@@ -0,0 +1,83 @@
+package checkmein
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultRosterCacheTTL = 2 * time.Minute
+
+// RosterCache keeps the member export for a short time. CheckMeIn can't look
+// up a single member, so without it reconciling one contact downloads the
+// whole roster. Uploads through the cache keep it current.
+type RosterCache struct {
+	Client *Client
+	TTL    time.Duration
+
+	// held while fetching, so concurrent lookups share one export
+	mu      sync.Mutex
+	members map[string]BulkAddMember
+	expires time.Time
+}
+
+func NewRosterCache(client *Client, ttl time.Duration) *RosterCache {
+	if ttl <= 0 {
+		ttl = defaultRosterCacheTTL
+	}
+	return &RosterCache{Client: client, TTL: ttl}
+}
+
+// Refresh always exports the roster, for callers that need every member
+func (rc *RosterCache) Refresh() ([]BulkAddMember, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.refresh()
+}
+
+// Lookup returns the members with the given barcodes, from the cache if it's fresh
+func (rc *RosterCache) Lookup(barcodes []string) ([]BulkAddMember, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if time.Now().After(rc.expires) {
+		_, err := rc.refresh()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var found []BulkAddMember
+	for _, barcode := range barcodes {
+		if m, ok := rc.members[barcode]; ok {
+			found = append(found, m)
+		}
+	}
+	return found, nil
+}
+
+// Upload sends rows to CheckMeIn and updates the cached copies
+func (rc *RosterCache) Upload(rows []BulkAddMember) error {
+	err := rc.Client.Upload(rows)
+	if err != nil {
+		return err
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.members != nil {
+		for _, row := range rows {
+			rc.members[row.Barcode] = row
+		}
+	}
+	return nil
+}
+
+func (rc *RosterCache) refresh() ([]BulkAddMember, error) {
+	roster, err := rc.Client.ListMembers()
+	if err != nil {
+		return nil, err
+	}
+	rc.members = make(map[string]BulkAddMember, len(roster))
+	for _, m := range roster {
+		rc.members[m.Barcode] = m
+	}
+	rc.expires = time.Now().Add(rc.TTL)
+	return roster, nil
+}
@@ -0,0 +1,54 @@
+package checkmein_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/checkmein"
+	"github.com/theforgeinitiative/integrations/checkmein/checkmeintest"
+)
+
+func TestRosterCache(t *testing.T) {
+	srv := checkmeintest.NewServer("admin", "secret")
+	defer srv.Close()
+	c := checkmein.NewClient(srv.URL, "admin", "secret")
+	rc := checkmein.NewRosterCache(&c, time.Minute)
+
+	srv.SetMembers([]checkmein.BulkAddMember{
+		{Barcode: "100", MembershipEndDate: "3/5/2024"},
+		{Barcode: "200", MembershipEndDate: "4/1/2024"},
+	})
+	found, err := rc.Lookup([]string{"100", "999"})
+	if err != nil {
+		t.Fatalf("Lookup: %s", err)
+	}
+	if len(found) != 1 || found[0].MembershipEndDate != "3/5/2024" {
+		t.Fatalf("Lookup = %+v", found)
+	}
+
+	// served from the cache until it expires
+	srv.SetMembers(nil)
+	found, err = rc.Lookup([]string{"200"})
+	if err != nil || len(found) != 1 {
+		t.Fatalf("cached Lookup = %+v, %v", found, err)
+	}
+
+	// uploads update the cached copy
+	err = rc.Upload([]checkmein.BulkAddMember{{Barcode: "100", MembershipEndDate: "3/5/2025"}})
+	if err != nil {
+		t.Fatalf("Upload: %s", err)
+	}
+	found, err = rc.Lookup([]string{"100"})
+	if err != nil || len(found) != 1 || found[0].MembershipEndDate != "3/5/2025" {
+		t.Errorf("Lookup after upload = %+v, %v", found, err)
+	}
+
+	// a refresh always goes back to CheckMeIn
+	roster, err := rc.Refresh()
+	if err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+	if len(roster) != 1 || roster[0].Barcode != "100" {
+		t.Errorf("Refresh = %+v", roster)
+	}
+}
@@ -0,0 +1,146 @@
+package checkmein
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/theforgeinitiative/integrations/metrics"
+)
+
+const loginPath = "/profile/loginAttempt"
+
+// where CheckMeIn sends anyone without a valid session, including failed logins
+const loginPagePath = "/profile/login"
+
+const sessionCookie = "session_id"
+
+var errLoginRequired = errors.New("checkmein session expired")
+
+// stopAtLogin hands redirects to the login page back to the caller so an
+// expired session can be detected, and otherwise follows redirects as usual
+func stopAtLogin(req *http.Request, via []*http.Request) error {
+	if req.URL.Path == loginPagePath {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+func isLoginRedirect(resp *http.Response) bool {
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return false
+	}
+	loc, err := resp.Location()
+	return err == nil && loc.Path == loginPagePath
+}
+
+// do sends a request built by newReq, logging in first if needed. If
+// CheckMeIn bounces us to the login page, it logs in again and retries once.
+func (c *Client) do(newReq func() (*http.Request, error)) (*http.Response, error) {
+	gen, err := c.authenticate(false, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate to checkmein: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if !isLoginRedirect(resp) {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if attempt > 0 {
+			return nil, errLoginRequired
+		}
+		gen, err = c.authenticate(true, gen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate to checkmein: %w", err)
+		}
+	}
+}
+
+// authenticate logs in if there's no session. With force it also logs in
+// when the session is still the one from generation seen, so requests that
+// all found the same session expired only log in once. It returns the
+// generation of the session to use.
+func (c *Client) authenticate(force bool, seen uint64) (uint64, error) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	base, err := url.Parse(c.URL)
+	if err != nil {
+		return c.sessionGen, fmt.Errorf("invalid checkmein url: %w", err)
+	}
+
+	// check if session cookie is still valid or was already replaced
+	if hasSession(c.httpClient.Jar, base) && (!force || c.sessionGen != seen) {
+		return c.sessionGen, nil
+	}
+
+	// drop the old cookie so a stale session can't be mistaken for a new one
+	c.httpClient.Jar.SetCookies(base, []*http.Cookie{{Name: sessionCookie, Path: "/", MaxAge: -1}})
+
+	form := url.Values{}
+	form.Set("username", c.Username)
+	form.Set("password", c.Password)
+	req, err := http.NewRequest("POST", c.URL+loginPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		return c.sessionGen, fmt.Errorf("failed to build login request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	done := metrics.Track("checkmein", "login")
+	err = c.login(req, base)
+	done(err)
+	if err != nil {
+		return c.sessionGen, err
+	}
+	c.sessionGen++
+	return c.sessionGen, nil
+}
+
+func (c *Client) login(req *http.Request, base *url.URL) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to login to checkmein: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// a rejected login still returns a page, so the status alone means nothing
+	if isLoginRedirect(resp) || !hasSession(c.httpClient.Jar, base) {
+		return errors.New("checkmein rejected the login")
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("received bad status from checkmein: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// statusError folds a bad status into the error for metrics
+func statusError(resp *http.Response, err error) error {
+	if err == nil && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received bad status from checkmein: %d", resp.StatusCode)
+	}
+	return err
+}
+
+func hasSession(jar http.CookieJar, u *url.URL) bool {
+	for _, cookie := range jar.Cookies(u) {
+		if cookie.Name == sessionCookie && len(cookie.Value) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package checkmein_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/theforgeinitiative/integrations/checkmein"
+	"github.com/theforgeinitiative/integrations/checkmein/checkmeintest"
+)
+
+func TestExpiredSessionLogsInAgain(t *testing.T) {
+	srv := checkmeintest.NewServer("admin", "secret")
+	defer srv.Close()
+	c := checkmein.NewClient(srv.URL, "admin", "secret")
+
+	_, err := c.ListMembers()
+	if err != nil {
+		t.Fatalf("ListMembers: %s", err)
+	}
+	srv.ExpireSessions()
+	_, err = c.ListMembers()
+	if err != nil {
+		t.Fatalf("ListMembers after expiry: %s", err)
+	}
+	if got := srv.LoginAttempts(); got != 2 {
+		t.Errorf("login attempts = %d, want 2", got)
+	}
+}
+
+func TestConcurrentExpiredSessionsShareOneLogin(t *testing.T) {
+	srv := checkmeintest.NewServer("admin", "secret")
+	defer srv.Close()
+	c := checkmein.NewClient(srv.URL, "admin", "secret")
+
+	_, err := c.ListMembers()
+	if err != nil {
+		t.Fatalf("ListMembers: %s", err)
+	}
+	srv.ExpireSessions()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.ListMembers()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("ListMembers: %s", err)
+		}
+	}
+	if got := srv.LoginAttempts(); got != 2 {
+		t.Errorf("login attempts = %d, want 2", got)
+	}
+}
+
+func TestRejectedLogin(t *testing.T) {
+	srv := checkmeintest.NewServer("admin", "secret")
+	defer srv.Close()
+	c := checkmein.NewClient(srv.URL, "admin", "wrong")
+
+	_, err := c.ListMembers()
+	if err == nil {
+		t.Fatal("expected an error for bad credentials")
+	}
+}
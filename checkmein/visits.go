@@ -0,0 +1,139 @@
+package checkmein
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gocarina/gocsv"
+	"github.com/theforgeinitiative/integrations/metrics"
+)
+
+// DefaultVisitsExportPath is where we expect the visit export. Like the
+// member export it isn't documented by CheckMeIn or confirmed against a live
+// instance, so set checkmein.visitsExportPath to what the deployment serves.
+// There's no known way to filter it by barcode, hence VisitCache.
+const DefaultVisitsExportPath = "/reports/exportVisits"
+
+const defaultVisitCacheTTL = 5 * time.Minute
+
+const VisitTimeFormat = "2006-01-02 15:04:05"
+
+// dates for the report range, not the visits themselves
+const visitsQueryDateFormat = "2006-01-02"
+
+// Visit is a row of the visit export. The column names are our best guess at
+// CheckMeIn's report, unconfirmed like the export path.
+type Visit struct {
+	Barcode     string `csv:"Barcode"`
+	DisplayName string `csv:"Display Name"`
+	CheckIn     string `csv:"Check In"`
+	// empty if the member forgot to check out
+	CheckOut string `csv:"Check Out"`
+}
+
+type VisitSummary struct {
+	Barcode   string
+	Count     int
+	Duration  time.Duration
+	LastVisit time.Time
+}
+
+func (v Visit) In() (time.Time, error) {
+	return time.ParseInLocation(VisitTimeFormat, v.CheckIn, time.Local)
+}
+
+func (v Visit) Out() (time.Time, error) {
+	return time.ParseInLocation(VisitTimeFormat, v.CheckOut, time.Local)
+}
+
+// Visits returns check-in history for visits starting between start and end
+func (c *Client) Visits(start, end time.Time) ([]Visit, error) {
+	q := url.Values{}
+	q.Set("startDate", start.Format(visitsQueryDateFormat))
+	q.Set("endDate", end.Format(visitsQueryDateFormat))
+	done := metrics.Track("checkmein", "visits")
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest("GET", c.URL+c.VisitsExportPath+"?"+q.Encode(), nil)
+	})
+	done(statusError(resp, err))
+	if err != nil {
+		return nil, fmt.Errorf("failed to export checkmein visits: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received bad status from checkmein: %d", resp.StatusCode)
+	}
+
+	var visits []Visit
+	err = gocsv.Unmarshal(resp.Body, &visits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse visit export: %w", err)
+	}
+	return visits, nil
+}
+
+// SummarizeVisits aggregates visits by barcode. Visits without a check out
+// still count, but don't add to the total duration.
+func SummarizeVisits(visits []Visit) map[string]VisitSummary {
+	summaries := make(map[string]VisitSummary)
+	for _, v := range visits {
+		in, err := v.In()
+		if err != nil {
+			continue
+		}
+		s := summaries[v.Barcode]
+		s.Barcode = v.Barcode
+		s.Count++
+		if in.After(s.LastVisit) {
+			s.LastVisit = in
+		}
+		if out, err := v.Out(); err == nil && out.After(in) {
+			s.Duration += out.Sub(in)
+		}
+		summaries[v.Barcode] = s
+	}
+	return summaries
+}
+
+// VisitCache keeps recent summaries by window length so per-member lookups
+// like /my-visits don't download the whole export every time
+type VisitCache struct {
+	Client *Client
+	TTL    time.Duration
+
+	// held while fetching, so concurrent lookups share one export
+	mu      sync.Mutex
+	entries map[int]cachedVisits
+}
+
+type cachedVisits struct {
+	summaries map[string]VisitSummary
+	expires   time.Time
+}
+
+func NewVisitCache(client *Client, ttl time.Duration) *VisitCache {
+	if ttl <= 0 {
+		ttl = defaultVisitCacheTTL
+	}
+	return &VisitCache{Client: client, TTL: ttl, entries: make(map[int]cachedVisits)}
+}
+
+// Summaries returns visit summaries by barcode for the last days days
+func (vc *VisitCache) Summaries(days int) (map[string]VisitSummary, error) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	now := time.Now()
+	if e, ok := vc.entries[days]; ok && now.Before(e.expires) {
+		return e.summaries, nil
+	}
+	visits, err := vc.Client.Visits(now.AddDate(0, 0, -days), now)
+	if err != nil {
+		return nil, err
+	}
+	summaries := SummarizeVisits(visits)
+	vc.entries[days] = cachedVisits{summaries: summaries, expires: now.Add(vc.TTL)}
+	return summaries, nil
+}
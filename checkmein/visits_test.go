@@ -0,0 +1,112 @@
+package checkmein_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gocarina/gocsv"
+	"github.com/theforgeinitiative/integrations/checkmein"
+	"github.com/theforgeinitiative/integrations/checkmein/checkmeintest"
+)
+
+func loadVisits(t *testing.T) []checkmein.Visit {
+	t.Helper()
+	f, err := os.Open("testdata/visits.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var visits []checkmein.Visit
+	err = gocsv.Unmarshal(f, &visits)
+	if err != nil {
+		t.Fatalf("failed to parse fixture: %s", err)
+	}
+	return visits
+}
+
+func visitTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	v, err := time.ParseInLocation(checkmein.VisitTimeFormat, s, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestSummarizeVisits(t *testing.T) {
+	visits := loadVisits(t)
+	if len(visits) != 6 || visits[0].Barcode != "100" || visits[0].CheckIn != "2024-03-01 18:00:00" {
+		t.Fatalf("fixture parsed as %+v", visits)
+	}
+
+	summaries := checkmein.SummarizeVisits(visits)
+	if len(summaries) != 2 {
+		t.Errorf("got summaries for %d barcodes, want 2 (bad check ins are skipped)", len(summaries))
+	}
+
+	jane := summaries["100"]
+	if jane.Count != 3 {
+		t.Errorf("jane count = %d, want 3", jane.Count)
+	}
+	// the last visit is the latest check in, not the last row
+	if want := visitTime(t, "2024-03-08 18:00:00"); !jane.LastVisit.Equal(want) {
+		t.Errorf("jane last visit = %s, want %s", jane.LastVisit, want)
+	}
+	// the visit without a check out doesn't add time
+	if jane.Duration != 3*time.Hour+30*time.Minute {
+		t.Errorf("jane duration = %s", jane.Duration)
+	}
+
+	sam := summaries["200"]
+	// checking out before checking in still counts as a visit, but not time
+	if sam.Count != 2 || sam.Duration != time.Hour {
+		t.Errorf("sam = %+v", sam)
+	}
+}
+
+func TestVisits(t *testing.T) {
+	srv := checkmeintest.NewServer("admin", "secret")
+	defer srv.Close()
+	srv.SetVisits(loadVisits(t))
+	c := checkmein.NewClient(srv.URL, "admin", "secret")
+
+	visits, err := c.Visits(time.Now().AddDate(0, 0, -30), time.Now())
+	if err != nil {
+		t.Fatalf("Visits: %s", err)
+	}
+	if len(visits) != 6 || visits[1].CheckOut != "" {
+		t.Errorf("Visits = %+v", visits)
+	}
+
+	c.VisitsExportPath = "/reports/missing"
+	_, err = c.Visits(time.Now().AddDate(0, 0, -30), time.Now())
+	if err == nil {
+		t.Error("expected an error for a missing export")
+	}
+}
+
+func TestVisitCache(t *testing.T) {
+	srv := checkmeintest.NewServer("admin", "secret")
+	defer srv.Close()
+	srv.SetVisits(loadVisits(t))
+	c := checkmein.NewClient(srv.URL, "admin", "secret")
+	vc := checkmein.NewVisitCache(&c, time.Hour)
+
+	summaries, err := vc.Summaries(30)
+	if err != nil || summaries["100"].Count != 3 {
+		t.Fatalf("Summaries = %+v, %v", summaries, err)
+	}
+
+	// served from the cache until it expires
+	srv.SetVisits(nil)
+	summaries, err = vc.Summaries(30)
+	if err != nil || summaries["100"].Count != 3 {
+		t.Errorf("cached Summaries = %+v, %v", summaries, err)
+	}
+	// each window is cached separately
+	summaries, err = vc.Summaries(7)
+	if err != nil || len(summaries) != 0 {
+		t.Errorf("Summaries(7) = %+v, %v", summaries, err)
+	}
+}
@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/spf13/viper"
+	"github.com/theforgeinitiative/integrations/checkmein"
 	"github.com/theforgeinitiative/integrations/config"
+	"github.com/theforgeinitiative/integrations/discord"
 	"github.com/theforgeinitiative/integrations/discord/bot"
 	"github.com/theforgeinitiative/integrations/groups"
+	"github.com/theforgeinitiative/integrations/health"
 	"github.com/theforgeinitiative/integrations/igloohome"
 	"github.com/theforgeinitiative/integrations/mail"
+	"github.com/theforgeinitiative/integrations/metrics"
 	"github.com/theforgeinitiative/integrations/mq"
+	"github.com/theforgeinitiative/integrations/reconcile"
 	"github.com/theforgeinitiative/integrations/sfdc"
 	"github.com/theforgeinitiative/integrations/sheetlog"
 )
@@ -43,6 +53,11 @@ func main() {
 		log.Fatalf("Groups client err: %s", err)
 	}
 
+	mgc, err := groups.NewClient(viper.GetString("groups.members.email"))
+	if err != nil {
+		log.Fatalf("Members groups client err: %s", err)
+	}
+
 	// igloohome client
 	var locks []map[string]string
 	viper.UnmarshalKey("storage.locks", &locks)
@@ -57,25 +72,81 @@ func main() {
 		log.Fatalf("Sheet client err: %s", err)
 	}
 
+	// door log goes to its own sheet
+	dl, err := sheetlog.NewClient(viper.GetString("doors.log.sheetId"), viper.GetString("doors.log.sheetName"))
+	if err != nil {
+		log.Fatalf("Door sheet client err: %s", err)
+	}
+
+	// checkmein client
+	cc := checkmein.NewClient(viper.GetString("checkmein.url"), viper.GetString("checkmein.username"), viper.GetString("checkmein.password"))
+	if viper.IsSet("checkmein.membersExportPath") {
+		cc.MembersExportPath = viper.GetString("checkmein.membersExportPath")
+	}
+	if viper.IsSet("checkmein.visitsExportPath") {
+		cc.VisitsExportPath = viper.GetString("checkmein.visitsExportPath")
+	}
+
 	// email client
-	mc := mail.NewClient(viper.GetString("mail.apiKey"), viper.GetString("mail.fromName"), viper.GetString("mail.fromEmail"), viper.GetString("mail.fromEmail"))
+	var transportConfig mail.TransportConfig
+	err = viper.UnmarshalKey("mail", &transportConfig)
+	if err != nil {
+		log.Fatalf("Failed to parse mail config: %s", err)
+	}
+	transport, err := mail.NewTransport(transportConfig)
+	if err != nil {
+		log.Fatalf("Mail transport err: %s", err)
+	}
+	mc := mail.NewClient(transport, viper.GetString("mail.fromName"), viper.GetString("mail.fromEmail"), viper.GetString("mail.fromEmail"))
+	mc.TemplateIDs = viper.GetStringMapString("mail.templateIds")
 
 	// mqtt client
-	mq, err := mq.NewClient(viper.GetString("mqtt.broker"))
+	var mqConfig mq.Config
+	err = viper.UnmarshalKey("mqtt", &mqConfig)
+	if err != nil {
+		log.Fatalf("Failed to read MQTT config: %s", err)
+	}
+	mqConfig.OnConnect = func() {
+		log.Printf("Connected to MQTT broker %s", mqConfig.Broker)
+	}
+	mqConfig.OnDisconnect = func(err error) {
+		log.Printf("Lost connection to MQTT broker %s: %s", mqConfig.Broker, err)
+	}
+	mqc, err := mq.NewClient(mqConfig)
 	if err != nil {
 		log.Printf("MQTT Client err: %s", err)
 	}
+	if mqc == nil {
+		log.Fatalf("Failed to create MQTT client")
+	}
+	err = viper.UnmarshalKey("doors.list", &mqc.Doors)
+	if err != nil {
+		log.Fatalf("Failed to read door config: %s", err)
+	}
+	mqc.UnlockKey = []byte(viper.GetString("doors.unlockKey"))
+	mqc.UnlockTTL = viper.GetDuration("doors.unlockTTL")
+	if tz := viper.GetString("doors.timezone"); len(tz) > 0 {
+		mqc.Location, err = time.LoadLocation(tz)
+		if err != nil {
+			log.Fatalf("Invalid doors.timezone %q: %s", tz, err)
+		}
+	}
+
 	// register handlers/commands
 	botClient := bot.Bot{
-		Session:         sess,
-		SFClient:        &sfClient,
-		GroupClient:     &gc,
-		ID:              viper.GetString("discord.botId"),
-		Campaigns:       viper.GetStringMapString("sfdc.campaigns"),
-		IglooHomeClient: ih,
-		SheetLog:        &sl,
-		MailClient:      &mc,
-		MQClient:        &mq,
+		Session:            sess,
+		SFClient:           &sfClient,
+		GroupClient:        &gc,
+		MembersGroupClient: &mgc,
+		ID:                 viper.GetString("discord.botId"),
+		Campaigns:          viper.GetStringMapString("sfdc.campaigns"),
+		IglooHomeClient:    ih,
+		SheetLog:           &sl,
+		DoorLog:            &dl,
+		MailClient:         &mc,
+		MQClient:           mqc,
+		CheckMeInClient:    &cc,
+		VisitCache:         checkmein.NewVisitCache(&cc, viper.GetDuration("checkmein.visitsCacheTTL")),
 	}
 
 	err = viper.UnmarshalKey("discord.guilds", &botClient.Guilds)
@@ -83,6 +154,23 @@ func main() {
 		log.Fatal("Failed to read Discord guild config", err)
 	}
 
+	discordClient := &discord.Client{BotSession: sess, Guilds: botClient.Guilds}
+
+	// moderators can reconcile one member with /sync
+	botClient.Reconciler = &reconcile.Reconciler{
+		SFClient:            &sfClient,
+		GroupsClient:        &mgc,
+		GroupExceptions:     viper.GetStringSlice("groups.members.exceptions"),
+		CheckMeInExceptions: viper.GetStringSlice("checkmein.exceptions"),
+		ManagerCampaign:     viper.GetString("sfdc.campaigns.board"),
+		ManagerStatus:       viper.GetString("sfdc.campaigns.boardStatus"),
+		DiscordClient:       discordClient,
+		CheckMeInClient:     &cc,
+		CheckMeInRoster:     checkmein.NewRosterCache(&cc, viper.GetDuration("checkmein.rosterCacheTTL")),
+		MQClient:            mqc,
+		Logger:              reconcile.StdLogger{},
+	}
+
 	botClient.RegisterCommands()
 	botClient.RegisterHandlers()
 
@@ -90,10 +178,78 @@ func main() {
 	if err != nil {
 		log.Fatalf("Cannot open the session: %v", err)
 	}
-	defer sess.Close()
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
-	<-stop
+	// probes, Cloud Run also needs something listening on PORT
+	checker := health.NewChecker()
+	if viper.IsSet("health.cacheTTL") {
+		checker.CacheTTL = viper.GetDuration("health.cacheTTL")
+	}
+	checker.Add("sfdc", sfClient.Ping)
+	checker.Add("discord", discordClient.CheckGateway)
+	checker.Add("mqtt", mqc.Ping)
+	checker.Add("google", gc.Ping)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", checker.Healthz)
+	mux.HandleFunc("/readyz", checker.Readyz)
+	srv := &http.Server{
+		Addr:              config.ListenAddress("bot.port", "8080"),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Health server err: %s", err)
+		}
+	}()
+
+	// metrics aren't authenticated, so they only go on an internal port
+	var metricsSrv *http.Server
+	if port := viper.GetString("bot.metricsPort"); len(port) > 0 {
+		metricsSrv = &http.Server{
+			Addr:              ":" + port,
+			Handler:           metrics.Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			err := metricsSrv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Metrics server err: %s", err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
 	log.Println("Graceful shutdown")
+	checker.Drain()
+	// give load balancers time to see the failing readiness probe
+	if delay := viper.GetDuration("bot.preStopDelay"); delay > 0 {
+		log.Printf("Waiting %s before shutting down", delay)
+		time.Sleep(delay)
+	}
+
+	timeout := viper.GetDuration("bot.shutdownTimeout")
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	// stop taking new events, responding to the ones we have only needs REST
+	err = sess.Close()
+	if err != nil {
+		log.Printf("Failed to close discord session: %s", err)
+	}
+	if !botClient.Drain(timeout) {
+		log.Printf("Gave up waiting for interactions after %s", timeout)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	if metricsSrv != nil {
+		err = metricsSrv.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Printf("Failed to shut down metrics server: %s", err)
+		}
+	}
+	mqc.Close()
 }
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -11,16 +17,26 @@ import (
 	"github.com/theforgeinitiative/integrations/api"
 	"github.com/theforgeinitiative/integrations/checkmein"
 	"github.com/theforgeinitiative/integrations/config"
+	"github.com/theforgeinitiative/integrations/db"
 	"github.com/theforgeinitiative/integrations/discord"
 	"github.com/theforgeinitiative/integrations/groups"
+	"github.com/theforgeinitiative/integrations/health"
 	"github.com/theforgeinitiative/integrations/mail"
+	"github.com/theforgeinitiative/integrations/metrics"
+	"github.com/theforgeinitiative/integrations/mq"
+	"github.com/theforgeinitiative/integrations/scheduler"
 	"github.com/theforgeinitiative/integrations/sfdc"
 )
 
 func main() {
 	e := echo.New()
 	e.Pre(middleware.RemoveTrailingSlash())
-	e.Use(middleware.Logger())
+	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
+		// probes would drown out everything else, and outbound message URLs carry a token
+		Skipper: func(c echo.Context) bool {
+			return c.Path() == "/healthz" || c.Path() == "/readyz" || c.Path() == api.SFDCOutboundPath
+		},
+	}))
 	e.Logger.SetLevel(log.INFO)
 
 	err := config.LoadConfig()
@@ -28,12 +44,39 @@ func main() {
 		e.Logger.Fatalf("Failed to load config: %s", err)
 	}
 
+	// DB config is optional, it's only needed for api keys
+	var firestoreClient *db.Client
+	var apiKeys *api.APIKeys
+	if viper.IsSet("gcp.projectId") {
+		firestoreClient, err = db.NewClient(viper.GetString("gcp.projectId"))
+		if err != nil {
+			e.Logger.Fatal("Failed to create Firestore client", err)
+		}
+		apiKeys = api.NewAPIKeys(firestoreClient)
+	}
+
+	// every api route needs auth, unless it's turned off for local development
+	authRequired := api.AllowAll
 	if viper.GetBool("auth.enabled") {
-		authMW, err := api.NewAuthMiddleware(viper.GetString("auth.oauthIssuer"), viper.GetString("auth.audience"), viper.GetStringSlice("auth.gcpPrincipals"))
+		var gcpPrincipals map[string][]string
+		switch viper.Get("auth.gcpPrincipals").(type) {
+		case []interface{}, string:
+			e.Logger.Warn("auth.gcpPrincipals is a list, so each principal only gets the reconcile scopes. Configure it as a map of principal to scopes instead.")
+			gcpPrincipals = api.LegacyPrincipals(viper.GetStringSlice("auth.gcpPrincipals"))
+		default:
+			err = viper.UnmarshalKey("auth.gcpPrincipals", &gcpPrincipals)
+			if err != nil {
+				e.Logger.Fatalf("Failed to read auth.gcpPrincipals, expected a map of principal to scopes: %s", err)
+			}
+		}
+		authMW, err := api.NewAuthMiddleware(viper.GetString("auth.oauthIssuer"), viper.GetString("auth.audience"), gcpPrincipals)
 		if err != nil {
 			e.Logger.Fatalf("Failed to initialize auth middleware: %s", err)
 		}
-		e.Use(authMW.Require)
+		authMW.APIKeys = apiKeys
+		authRequired = authMW.Require
+	} else {
+		e.Logger.Warn("Auth is disabled, all API requests get every scope")
 	}
 
 	// setup SFDC connection
@@ -41,12 +84,8 @@ func main() {
 	if err != nil {
 		e.Logger.Fatal("Failed to create SFDC client", err)
 	}
-
-	// // DB config
-	// firestoreClient, err := db.NewClient(gcpProjectID)
-	// if err != nil {
-	// 	e.Logger.Fatal("Failed to create Firestore client", err)
-	// }
+	sfClient.VisitCountField = viper.GetString("sfdc.visitCountField")
+	sfClient.LastVisitField = viper.GetString("sfdc.lastVisitField")
 
 	// setup Discord session
 	discordClient, err := discord.NewClient(viper.GetString("discord.botToken"))
@@ -66,26 +105,204 @@ func main() {
 
 	// checkmein client
 	cc := checkmein.NewClient(viper.GetString("checkmein.url"), viper.GetString("checkmein.username"), viper.GetString("checkmein.password"))
+	if viper.IsSet("checkmein.membersExportPath") {
+		cc.MembersExportPath = viper.GetString("checkmein.membersExportPath")
+	}
+	if viper.IsSet("checkmein.visitsExportPath") {
+		cc.VisitsExportPath = viper.GetString("checkmein.visitsExportPath")
+	}
 
 	// email client
-	mc := mail.NewClient(viper.GetString("mail.apiKey"), viper.GetString("mail.fromName"), viper.GetString("mail.fromEmail"), viper.GetString("mail.to"))
+	var transportConfig mail.TransportConfig
+	err = viper.UnmarshalKey("mail", &transportConfig)
+	if err != nil {
+		log.Fatalf("Failed to parse mail config: %s", err)
+	}
+	transport, err := mail.NewTransport(transportConfig)
+	if err != nil {
+		log.Fatalf("Mail transport err: %s", err)
+	}
+	mc := mail.NewClient(transport, viper.GetString("mail.fromName"), viper.GetString("mail.fromEmail"), viper.GetString("mail.to"))
+	mc.TemplateIDs = viper.GetStringMapString("mail.templateIds")
+
+	// mqtt client is optional for the server, it only publishes events
+	var mqc *mq.Client
+	if viper.IsSet("mqtt.broker") {
+		var mqConfig mq.Config
+		err = viper.UnmarshalKey("mqtt", &mqConfig)
+		if err != nil {
+			e.Logger.Fatal("Failed to read MQTT config", err)
+		}
+		mqc, err = mq.NewClient(mqConfig)
+		if err != nil {
+			e.Logger.Warnf("MQTT client err: %s", err)
+		}
+	}
+
+	var webhookConfig api.SFDCWebhookConfig
+	err = viper.UnmarshalKey("sfdc.webhook", &webhookConfig)
+	if err != nil {
+		e.Logger.Fatal("Failed to read SFDC webhook config", err)
+	}
 
 	// create handler struct
 	app := api.Handlers{
-		SFClient:      &sfClient,
-		DiscordClient: discordClient,
-		//DBClient:        firestoreClient,
-		GroupsClient:    &gc,
-		GroupExceptions: viper.GetStringSlice("groups.members.exceptions"),
-		CheckMeInClient: &cc,
-		EmailClient:     &mc,
+		SFClient:            &sfClient,
+		DiscordClient:       discordClient,
+		DBClient:            firestoreClient,
+		GroupsClient:        &gc,
+		GroupExceptions:     viper.GetStringSlice("groups.members.exceptions"),
+		CheckMeInExceptions: viper.GetStringSlice("checkmein.exceptions"),
+		ManagerCampaign:     viper.GetString("sfdc.campaigns.board"),
+		ManagerStatus:       viper.GetString("sfdc.campaigns.boardStatus"),
+		CheckMeInClient:     &cc,
+		CheckMeInRoster:     checkmein.NewRosterCache(&cc, viper.GetDuration("checkmein.rosterCacheTTL")),
+		EmailClient:         &mc,
+		MQClient:            mqc,
+		APIKeys:             apiKeys,
+		MemberCache:         api.NewMemberCache(viper.GetDuration("members.cacheTTL")),
+		SFDCWebhook:         webhookConfig,
 	}
 
+	// optional in-process schedule, for deployments without Cloud Scheduler
+	var sched *scheduler.Scheduler
+	if spec := viper.GetString("schedule.reconcile"); len(spec) > 0 {
+		sched = scheduler.NewScheduler(e.Logger)
+		if firestoreClient != nil {
+			sched.Store = firestoreClient
+		} else {
+			e.Logger.Warn("No Firestore configured, every server instance will run scheduled reconciles")
+		}
+		if viper.IsSet("schedule.leaseTTL") {
+			sched.LeaseTTL = viper.GetDuration("schedule.leaseTTL")
+		}
+		err = sched.Add("reconcile", spec, func() (string, error) {
+			report, err := app.RunReconcile(e.Logger, "scheduler", nil)
+			if err != nil {
+				return "", err
+			}
+			if report.HasErrors() {
+				return report.Summary(), errors.New("some changes failed")
+			}
+			return report.Summary(), nil
+		})
+		if err != nil {
+			e.Logger.Fatalf("Failed to schedule reconcile: %s", err)
+		}
+		sched.Start()
+	}
+
+	// probes
+	checker := health.NewChecker()
+	if viper.IsSet("health.cacheTTL") {
+		checker.CacheTTL = viper.GetDuration("health.cacheTTL")
+	}
+	checker.Add("sfdc", sfClient.Ping)
+	checker.Add("discord", discordClient.Ping)
+	checker.Add("google", gc.Ping)
+	if viper.IsSet("mqtt.broker") {
+		checker.Add("mqtt", func() error {
+			if mqc == nil {
+				return errors.New("mqtt client wasn't created")
+			}
+			return mqc.Ping()
+		})
+	}
+	e.GET("/healthz", echo.WrapHandler(http.HandlerFunc(checker.Healthz)))
+	e.GET("/readyz", echo.WrapHandler(http.HandlerFunc(checker.Readyz)))
+
 	// api routes
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "🤖🛠️😎")
 	})
-	e.POST("/api/v1/reconcile", app.Reconcile)
+	v1 := e.Group("/api/v1", authRequired)
+	v1.POST("/reconcile", app.Reconcile, api.RequireScope(api.ScopeReconcileRead))
+	v1.POST("/reconcile/contact/:id", app.ReconcileContact, api.RequireScope(api.ScopeReconcileRead))
+	v1.GET("/reconcile/diff", app.ReconcileDiff, api.RequireScope(api.ScopeReconcileRead))
+	v1.POST("/attendance/import", app.ImportAttendance, api.RequireScope(api.ScopeAttendanceWrite))
+	v1.GET("/members", app.LookupMember, api.RequireScope(api.ScopeMembersRead))
+	if apiKeys != nil {
+		keys := v1.Group("/apikeys", api.RequireScope(api.ScopeAPIKeysAdmin))
+		keys.POST("", app.CreateAPIKey)
+		keys.GET("", app.ListAPIKeys)
+		keys.DELETE("/:id", app.RevokeAPIKey)
+		keys.POST("/:id/rotate", app.RotateAPIKey)
+	}
+	// salesforce webhooks verify themselves, so they're outside the auth group
+	if len(webhookConfig.OrganizationID) > 0 && len(webhookConfig.Token) > 0 {
+		e.POST(api.SFDCOutboundPath, app.SFDCOutboundMessage)
+	}
+	if len(webhookConfig.Secret) > 0 {
+		e.POST(api.SFDCEventsPath, app.SFDCPlatformEvents)
+	}
 
-	e.Logger.Fatal(e.Start(":3000"))
+	// metrics aren't authenticated, so they only go on an internal port
+	var metricsSrv *http.Server
+	if port := viper.GetString("server.metricsPort"); len(port) > 0 {
+		metricsSrv = &http.Server{
+			Addr:              ":" + port,
+			Handler:           metrics.Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			err := metricsSrv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				e.Logger.Fatalf("Metrics server err: %s", err)
+			}
+		}()
+	}
+
+	go func() {
+		err := e.Start(config.ListenAddress("server.port", "3000"))
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatalf("Server err: %s", err)
+		}
+	}()
+
+	// Cloud Run sends SIGTERM and waits 10 seconds before killing us
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	e.Logger.Info("Shutting down")
+	checker.Drain()
+	// give load balancers time to see the failing readiness probe
+	if delay := viper.GetDuration("server.preStopDelay"); delay > 0 {
+		e.Logger.Infof("Waiting %s before shutting down", delay)
+		time.Sleep(delay)
+	}
+
+	timeout := viper.GetDuration("server.shutdownTimeout")
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// no new scheduled runs, but let a running one finish alongside requests
+	jobsDone := context.Background()
+	if sched != nil {
+		jobsDone = sched.Stop()
+	}
+	err = e.Shutdown(shutdownCtx)
+	if err != nil {
+		e.Logger.Errorf("Failed to drain requests: %s", err)
+	}
+	if sched != nil {
+		select {
+		case <-jobsDone.Done():
+		case <-shutdownCtx.Done():
+			e.Logger.Warn("Scheduled reconcile was still running at shutdown")
+		}
+	}
+	if apiKeys != nil {
+		apiKeys.Wait()
+	}
+	if metricsSrv != nil {
+		err = metricsSrv.Shutdown(shutdownCtx)
+		if err != nil {
+			e.Logger.Errorf("Failed to shut down metrics server: %s", err)
+		}
+	}
+	if mqc != nil {
+		mqc.Close()
+	}
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/viper"
 )
@@ -18,3 +19,16 @@ func LoadConfig() error {
 	}
 	return nil
 }
+
+// ListenAddress uses the PORT environment variable when it's set, like on
+// Cloud Run, then the port configured at key
+func ListenAddress(key, defaultPort string) string {
+	port := os.Getenv("PORT")
+	if len(port) == 0 {
+		port = viper.GetString(key)
+	}
+	if len(port) == 0 {
+		port = defaultPort
+	}
+	return ":" + port
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const APIKeyCollection = "api_keys"
+
+// APIKey is stored under its public ID. Only a hash of the secret is kept.
+type APIKey struct {
+	ID        string    `firestore:"-" json:"id"`
+	Name      string    `firestore:"name" json:"name"`
+	Hash      string    `firestore:"hash" json:"-"`
+	Scopes    []string  `firestore:"scopes" json:"scopes"`
+	CreatedBy string    `firestore:"created_by" json:"createdBy"`
+	CreatedAt time.Time `firestore:"created_at" json:"createdAt"`
+	// zero means the key never expires
+	ExpiresAt time.Time `firestore:"expires_at" json:"expiresAt"`
+	LastUsed  time.Time `firestore:"last_used" json:"lastUsed"`
+	Revoked   bool      `firestore:"revoked" json:"revoked"`
+	RevokedAt time.Time `firestore:"revoked_at" json:"revokedAt"`
+}
+
+// ErrNotFound is returned when a document doesn't exist
+var ErrNotFound = errors.New("not found")
+
+func (c *Client) CreateAPIKey(key APIKey) error {
+	_, err := c.FirestoreClient.Collection(APIKeyCollection).Doc(key.ID).Create(context.Background(), key)
+	if err != nil {
+		return fmt.Errorf("failed to create api key %s: %w", key.ID, err)
+	}
+	return nil
+}
+
+func (c *Client) GetAPIKey(id string) (APIKey, error) {
+	doc, err := c.FirestoreClient.Collection(APIKeyCollection).Doc(id).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		return APIKey{}, ErrNotFound
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to get api key %s: %w", id, err)
+	}
+	var key APIKey
+	err = doc.DataTo(&key)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to decode api key %s: %w", id, err)
+	}
+	key.ID = doc.Ref.ID
+	return key, nil
+}
+
+func (c *Client) ListAPIKeys() ([]APIKey, error) {
+	iter := c.FirestoreClient.Collection(APIKeyCollection).OrderBy("created_at", firestore.Asc).Documents(context.Background())
+	var keys []APIKey
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var key APIKey
+		err = doc.DataTo(&key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode api key %s: %w", doc.Ref.ID, err)
+		}
+		key.ID = doc.Ref.ID
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (c *Client) RevokeAPIKey(id string) error {
+	_, err := c.FirestoreClient.Collection(APIKeyCollection).Doc(id).Update(context.Background(), []firestore.Update{
+		{Path: "revoked", Value: true},
+		{Path: "revoked_at", Value: time.Now()},
+	})
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (c *Client) TouchAPIKey(id string, used time.Time) error {
+	_, err := c.FirestoreClient.Collection(APIKeyCollection).Doc(id).Update(context.Background(), []firestore.Update{
+		{Path: "last_used", Value: used},
+	})
+	return err
+}
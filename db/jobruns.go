@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const JobRunCollection = "job_runs"
+
+const (
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	// the previous run on the same instance was still going
+	JobSkipped = "skipped"
+)
+
+type JobRun struct {
+	Job        string    `firestore:"job" json:"job"`
+	Holder     string    `firestore:"holder" json:"holder"`
+	Status     string    `firestore:"status" json:"status"`
+	StartedAt  time.Time `firestore:"started_at" json:"startedAt"`
+	FinishedAt time.Time `firestore:"finished_at" json:"finishedAt"`
+	Summary    string    `firestore:"summary" json:"summary"`
+	Error      string    `firestore:"error" json:"error,omitempty"`
+}
+
+func (c *Client) RecordJobRun(run JobRun) error {
+	_, _, err := c.FirestoreClient.Collection(JobRunCollection).Add(context.Background(), run)
+	if err != nil {
+		return fmt.Errorf("failed to record %s run: %w", run.Job, err)
+	}
+	return nil
+}
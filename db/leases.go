@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const LeaseCollection = "leases"
+
+// Lease makes sure only one instance runs a scheduled job per tick
+type Lease struct {
+	Holder    string    `firestore:"holder"`
+	ExpiresAt time.Time `firestore:"expires_at"`
+	// the last tick anyone took the lease for, so a slow instance doesn't rerun it
+	LastTick time.Time `firestore:"last_tick"`
+}
+
+// AcquireLease takes the named lease for tick unless someone else holds it or
+// already took it for this tick
+func (c *Client) AcquireLease(name, holder string, tick time.Time, ttl time.Duration) (bool, error) {
+	ref := c.FirestoreClient.Collection(LeaseCollection).Doc(name)
+	acquired := false
+	err := c.FirestoreClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		acquired = false
+		now := time.Now()
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var lease Lease
+			err = doc.DataTo(&lease)
+			if err != nil {
+				return err
+			}
+			if !lease.LastTick.Before(tick) {
+				return nil
+			}
+			if lease.Holder != holder && now.Before(lease.ExpiresAt) {
+				return nil
+			}
+		}
+		acquired = true
+		return tx.Set(ref, Lease{Holder: holder, ExpiresAt: now.Add(ttl), LastTick: tick})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease %s: %w", name, err)
+	}
+	return acquired, nil
+}
+
+// ReleaseLease lets the lease expire now if holder still has it
+func (c *Client) ReleaseLease(name, holder string) error {
+	ref := c.FirestoreClient.Collection(LeaseCollection).Doc(name)
+	err := c.FirestoreClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var lease Lease
+		err = doc.DataTo(&lease)
+		if err != nil {
+			return err
+		}
+		if lease.Holder != holder {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{{Path: "expires_at", Value: time.Now()}})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release lease %s: %w", name, err)
+	}
+	return nil
+}
+
+// RenewLease pushes the expiry out by ttl while holder still has the lease.
+// It returns false if someone else has taken it.
+func (c *Client) RenewLease(name, holder string, ttl time.Duration) (bool, error) {
+	ref := c.FirestoreClient.Collection(LeaseCollection).Doc(name)
+	renewed := false
+	err := c.FirestoreClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		renewed = false
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var lease Lease
+		err = doc.DataTo(&lease)
+		if err != nil {
+			return err
+		}
+		if lease.Holder != holder {
+			return nil
+		}
+		renewed = true
+		return tx.Update(ref, []firestore.Update{{Path: "expires_at", Value: time.Now().Add(ttl)}})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lease %s: %w", name, err)
+	}
+	return renewed, nil
+}
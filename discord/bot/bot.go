@@ -2,33 +2,55 @@ package bot
 
 import (
 	"log"
+	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/theforgeinitiative/integrations/checkmein"
 	"github.com/theforgeinitiative/integrations/discord"
 	"github.com/theforgeinitiative/integrations/groups"
 	"github.com/theforgeinitiative/integrations/igloohome"
 	"github.com/theforgeinitiative/integrations/mail"
 	"github.com/theforgeinitiative/integrations/mq"
+	"github.com/theforgeinitiative/integrations/reconcile"
 	"github.com/theforgeinitiative/integrations/sfdc"
 	"github.com/theforgeinitiative/integrations/sheetlog"
 )
 
 type Bot struct {
-	Session         *discordgo.Session
-	SFClient        *sfdc.Client
-	GroupClient     *groups.Client
-	ID              string
-	Guilds          map[string]discord.Guild
-	Campaigns       map[string]string
-	IglooHomeClient *igloohome.Client
-	SheetLog        *sheetlog.Client
-	MailClient      *mail.Client
-	MQClient        *mq.Client
+	Session     *discordgo.Session
+	SFClient    *sfdc.Client
+	GroupClient *groups.Client
+	// the members group, for changes that shouldn't wait for reconcile
+	MembersGroupClient *groups.Client
+	ID                 string
+	Guilds             map[string]discord.Guild
+	Campaigns          map[string]string
+	IglooHomeClient    *igloohome.Client
+	SheetLog           *sheetlog.Client
+	DoorLog            *sheetlog.Client
+	MailClient         *mail.Client
+	MQClient           *mq.Client
+	CheckMeInClient    *checkmein.Client
+	VisitCache         *checkmein.VisitCache
+	Reconciler         *reconcile.Reconciler
+
+	// handlers still running, so shutdown can wait for them
+	inFlight sync.WaitGroup
+	// /groups-email codes waiting to be confirmed
+	verifications emailVerifications
 }
 
 const unknownMemberErrorCode = 10007
 
+var minVisitDays float64 = 1
+
+const maxVisitDays = 365
+const defaultVisitDays = 30
+
 var commands = []discordgo.ApplicationCommand{
+	groupsEmailCommand,
+	syncCommand,
 	{
 		Name:        "link-membership",
 		Description: "Link your user to your TFI membership",
@@ -37,6 +59,20 @@ var commands = []discordgo.ApplicationCommand{
 		Name:        "welcome",
 		Description: "Show welcome message with information about linking membership",
 	},
+	{
+		Name:        "my-visits",
+		Description: "Shows how often you've visited the shop",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "days",
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Required:    false,
+				Description: "How many days to look back (default 30)",
+				MinValue:    &minVisitDays,
+				MaxValue:    maxVisitDays,
+			},
+		},
+	},
 	{
 		Name:        "letmein",
 		Description: "Rings the doorbell in the LOFT",
@@ -86,8 +122,32 @@ func (b *Bot) RegisterHandlers() {
 	b.Session.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
 		log.Println("Bot is up!")
 	})
-	b.Session.AddHandler(b.newMemberHandler)
-	b.Session.AddHandler(b.interactionHandler)
+	b.Session.AddHandler(func(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+		b.inFlight.Add(1)
+		defer b.inFlight.Done()
+		b.newMemberHandler(s, m)
+	})
+	b.Session.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		b.inFlight.Add(1)
+		defer b.inFlight.Done()
+		b.interactionHandler(s, i)
+	})
+}
+
+// Drain waits for running handlers, up to timeout. Close the session first so
+// no new events come in.
+func (b *Bot) Drain(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		b.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 func (b *Bot) RegisterCommands() {
@@ -122,4 +182,33 @@ func (b *Bot) RegisterCommands() {
 	if err != nil {
 		log.Fatalf("Cannot create slash command %q: %v", "unlock-storage", err)
 	}
+
+	// same deal for doors, but only when there's a key to sign unlocks with
+	if !b.MQClient.UnlockEnabled() {
+		log.Printf("No door unlock key configured, not registering %q", "unlock-door")
+		return
+	}
+	doorCommand := discordgo.ApplicationCommand{
+		Name:        "unlock-door",
+		Description: "Remotely unlocks a TFI door",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "door",
+				Type:        discordgo.ApplicationCommandOptionType(discordgo.StringSelectMenu),
+				Required:    true,
+				Description: "Which door should be unlocked?",
+				Choices:     []*discordgo.ApplicationCommandOptionChoice{},
+			},
+		},
+	}
+	for _, door := range b.MQClient.Doors {
+		doorCommand.Options[0].Choices = append(doorCommand.Options[0].Choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  door.Label,
+			Value: door.ID,
+		})
+	}
+	_, err = b.Session.ApplicationCommandCreate(b.ID, "", &doorCommand)
+	if err != nil {
+		log.Fatalf("Cannot create slash command %q: %v", "unlock-door", err)
+	}
 }
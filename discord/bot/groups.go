@@ -0,0 +1,291 @@
+package bot
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"math/big"
+	netmail "net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/theforgeinitiative/integrations/mail"
+	"github.com/theforgeinitiative/integrations/reconcile"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+const verificationTTL = 15 * time.Minute
+const maxVerificationAttempts = 5
+
+// how many codes one user can have emailed per verificationTTL, so the bot
+// can't be used to flood someone's inbox
+const maxVerificationEmails = 3
+
+type emailVerification struct {
+	ContactID string
+	Field     string
+	Email     string
+	Code      string
+	Expires   time.Time
+	Attempts  int
+}
+
+// emailVerifications tracks codes by Discord user ID
+type emailVerifications struct {
+	mu      sync.Mutex
+	pending map[string]*emailVerification
+	// when each user was sent codes recently
+	sent map[string][]time.Time
+}
+
+// allowSend records a send for uid, unless they've had too many codes lately
+func (v *emailVerifications) allowSend(uid string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.sent == nil {
+		v.sent = make(map[string][]time.Time)
+	}
+	var recent []time.Time
+	for _, t := range v.sent[uid] {
+		if now.Sub(t) < verificationTTL {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= maxVerificationEmails {
+		v.sent[uid] = recent
+		return false
+	}
+	v.sent[uid] = append(recent, now)
+	return true
+}
+
+func (v *emailVerifications) set(uid string, ev *emailVerification) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.pending == nil {
+		v.pending = make(map[string]*emailVerification)
+	}
+	v.pending[uid] = ev
+}
+
+var groupsEmailCommand = discordgo.ApplicationCommand{
+	Name:        "groups-email",
+	Description: "Manage which addresses are on the members Google Group",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Name:        "show",
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Description: "Show the addresses on the members Google Group",
+		},
+		{
+			Name:        "set",
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Description: "Change an address on the members Google Group",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "email",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+					Description: "New address, we'll send it a verification code",
+				},
+				{
+					Name:        "slot",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+					Description: "Which address to replace (default primary)",
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{
+							Name:  "Primary",
+							Value: "primary",
+						},
+						{
+							Name:  "Secondary",
+							Value: "secondary",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "verify",
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Description: "Confirm a new address with the code we emailed you",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "code",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+					Description: "Verification code",
+				},
+			},
+		},
+	},
+}
+
+func (b *Bot) groupsEmailHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Looking up your membership... :thinking:",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	var uid string
+	if i.Member != nil {
+		uid = i.Member.User.ID
+	} else {
+		uid = i.User.ID
+	}
+	contact, err := b.SFClient.GetContactByDiscordID(uid)
+	if err != nil {
+		log.Printf("Failed to lookup member when managing group email: %s", err)
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: ":woozy_face: Oof! We encountered a problem. Please ensure you've linked your membership to your Discord account and try again.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+
+	sub := i.ApplicationCommandData().Options[0]
+	var msg string
+	switch sub.Name {
+	case "show":
+		msg = groupsEmailSummary(contact)
+	case "set":
+		msg = b.startEmailVerification(uid, contact, sub.Options)
+	case "verify":
+		msg = b.finishEmailVerification(uid, contact, sub.Options[0].StringValue())
+	}
+	s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: msg,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+}
+
+func groupsEmailSummary(contact sfdc.Contact) string {
+	primary, secondary := contact.GroupEmail, contact.GroupEmailAlt
+	if len(primary) == 0 {
+		primary = "_not set_"
+	}
+	if len(secondary) == 0 {
+		secondary = "_not set_"
+	}
+	return fmt.Sprintf(":envelope: Addresses on the members Google Group:\n**Primary:** %s\n**Secondary:** %s\n\nUse `/groups-email set` to change them.", primary, secondary)
+}
+
+func (b *Bot) startEmailVerification(uid string, contact sfdc.Contact, opts []*discordgo.ApplicationCommandInteractionDataOption) string {
+	field := sfdc.GroupEmailField
+	for _, o := range opts {
+		if o.Name == "slot" && o.StringValue() == "secondary" {
+			field = sfdc.GroupEmailSecondaryField
+		}
+	}
+	var email string
+	for _, o := range opts {
+		if o.Name == "email" {
+			email = strings.TrimSpace(o.StringValue())
+		}
+	}
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return ":face_with_monocle: That doesn't look like an email address. Please try again."
+	}
+
+	if !b.verifications.allowSend(uid, time.Now()) {
+		return fmt.Sprintf(":hourglass: We've already sent you %d codes recently. Please check your email or try again in a few minutes.", maxVerificationEmails)
+	}
+
+	code, err := verificationCode()
+	if err != nil {
+		log.Printf("Failed to generate verification code: %s", err)
+		return ":woozy_face: Oof! We encountered a problem. Please try again and ask for help if you're stuck."
+	}
+
+	err = b.MailClient.Send(mail.Recipients{To: []string{email}}, mail.VerificationCode{
+		FirstName: contact.FirstName,
+		Code:      code,
+		ValidFor:  verificationTTL,
+	})
+	if err != nil {
+		log.Printf("Failed to send verification email for %s: %s", contact.DisplayName, err)
+		return ":woozy_face: Oof! We couldn't send a verification email to that address. Please check it and try again."
+	}
+
+	b.verifications.set(uid, &emailVerification{
+		ContactID: contact.ID,
+		Field:     field,
+		Email:     email,
+		Code:      code,
+		Expires:   time.Now().Add(verificationTTL),
+	})
+
+	log.Printf("%s requested a group email change", contact.DisplayName)
+	return fmt.Sprintf(":incoming_envelope: We sent a code to **%s**. Run `/groups-email verify` with the code to finish.", email)
+}
+
+func (b *Bot) finishEmailVerification(uid string, contact sfdc.Contact, code string) string {
+	b.verifications.mu.Lock()
+	v, ok := b.verifications.pending[uid]
+	if ok && (time.Now().After(v.Expires) || v.ContactID != contact.ID) {
+		delete(b.verifications.pending, uid)
+		ok = false
+	}
+	if !ok {
+		b.verifications.mu.Unlock()
+		return ":hourglass: There's no pending address change. Start over with `/groups-email set`."
+	}
+	if subtle.ConstantTimeCompare([]byte(v.Code), []byte(strings.TrimSpace(code))) != 1 {
+		v.Attempts++
+		if v.Attempts >= maxVerificationAttempts {
+			delete(b.verifications.pending, uid)
+		}
+		b.verifications.mu.Unlock()
+		return ":x: That code didn't match. Please check the email and try again."
+	}
+	delete(b.verifications.pending, uid)
+	b.verifications.mu.Unlock()
+
+	old := contact.GroupEmail
+	other := contact.GroupEmailAlt
+	if v.Field == sfdc.GroupEmailSecondaryField {
+		old, other = other, old
+	}
+
+	err := b.SFClient.SetGroupEmail(contact.ID, v.Field, v.Email)
+	if err != nil {
+		log.Printf("Failed to update group email for %s: %s", contact.DisplayName, err)
+		return ":woozy_face: Oof! We had trouble saving your new address. Please try again."
+	}
+	log.Printf("%s changed a group email", contact.DisplayName)
+
+	if !contact.CurrentMember() {
+		return fmt.Sprintf(":white_check_mark: Saved! **%s** will be added to the members Google Group once your membership is current.", v.Email)
+	}
+
+	// don't wait for the next reconcile. it will fix up role and delivery settings later.
+	err = b.MembersGroupClient.AddMember(v.Email, "", "")
+	if err != nil {
+		log.Printf("Failed to add %s to members group: %s", v.Email, err)
+		return fmt.Sprintf(":warning: Saved **%s**, but we couldn't add it to the members Google Group just now. The next sync will try again.", v.Email)
+	}
+	// gmail ignores dots and case, so the old address may be the one we just added
+	if len(old) > 0 && !reconcile.SameGroupAddress(old, v.Email) && !reconcile.SameGroupAddress(old, other) {
+		err = b.MembersGroupClient.RemoveMember(old)
+		if err != nil {
+			log.Printf("Failed to remove %s from members group: %s", old, err)
+		}
+	}
+
+	return fmt.Sprintf(":white_check_mark: All set! **%s** is now on the members Google Group.", v.Email)
+}
+
+func verificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
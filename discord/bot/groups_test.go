@@ -0,0 +1,259 @@
+package bot
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/simpleforce/simpleforce"
+	"github.com/theforgeinitiative/integrations/googletest"
+	"github.com/theforgeinitiative/integrations/groups"
+	"github.com/theforgeinitiative/integrations/mail"
+	"github.com/theforgeinitiative/integrations/sfdc"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+const testMembersGroup = "members@example.org"
+
+// fakeSFDC records contact updates
+type fakeSFDC struct {
+	mu      sync.Mutex
+	updates []map[string]interface{}
+}
+
+func (f *fakeSFDC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch || !strings.Contains(r.URL.Path, "/sobjects/Contact/") {
+		http.NotFound(w, r)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var update map[string]interface{}
+	json.Unmarshal(body, &update)
+	f.mu.Lock()
+	f.updates = append(f.updates, update)
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type groupsEmailTest struct {
+	bot     *Bot
+	sf      *fakeSFDC
+	google  *googletest.Server
+	mail    *mail.CaptureTransport
+	contact sfdc.Contact
+}
+
+func newGroupsEmailTest(t *testing.T, members []*admin.Member) *groupsEmailTest {
+	t.Helper()
+	sf := &fakeSFDC{}
+	sfSrv := httptest.NewServer(sf)
+	t.Cleanup(sfSrv.Close)
+	sfc := simpleforce.NewClient(sfSrv.URL, "client", simpleforce.DefaultAPIVersion)
+	sfc.SetSidLoc("session", sfSrv.URL)
+
+	google := googletest.NewServer()
+	t.Cleanup(google.Close)
+	google.SetMembers(testMembersGroup, members)
+	gc, err := groups.NewClient(testMembersGroup, google.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("failed to create groups client: %s", err)
+	}
+
+	capture := mail.NewCaptureTransport()
+	mc := mail.NewClient(capture, "TFI", "noreply@example.org", "board@example.org")
+	return &groupsEmailTest{
+		bot: &Bot{
+			SFClient:           &sfdc.Client{SFClient: sfc},
+			MembersGroupClient: &gc,
+			MailClient:         &mc,
+		},
+		sf:     sf,
+		google: google,
+		mail:   capture,
+		contact: sfdc.Contact{
+			ID:               "003000000000001",
+			FirstName:        "Jane",
+			DisplayName:      "Jane D",
+			GroupEmail:       "old@example.org",
+			MembershipStatus: "Current",
+		},
+	}
+}
+
+func setOptions(email, slot string) []*discordgo.ApplicationCommandInteractionDataOption {
+	opts := []*discordgo.ApplicationCommandInteractionDataOption{
+		{Name: "email", Type: discordgo.ApplicationCommandOptionString, Value: email},
+	}
+	if len(slot) > 0 {
+		opts = append(opts, &discordgo.ApplicationCommandInteractionDataOption{Name: "slot", Type: discordgo.ApplicationCommandOptionString, Value: slot})
+	}
+	return opts
+}
+
+var codePattern = regexp.MustCompile(`\b\d{6}\b`)
+
+// emailedCode pulls the code out of the last verification email to addr
+func (gt *groupsEmailTest) emailedCode(t *testing.T, addr string) string {
+	t.Helper()
+	email, ok := gt.mail.Last()
+	if !ok || len(email.To) != 1 || email.To[0] != addr {
+		t.Fatalf("no verification email to %s, last was %+v", addr, email)
+	}
+	code := codePattern.FindString(email.Text)
+	if len(code) == 0 {
+		t.Fatalf("no code in verification email: %q", email.Text)
+	}
+	return code
+}
+
+func (gt *groupsEmailTest) groupEmails() map[string]bool {
+	emails := make(map[string]bool)
+	for _, m := range gt.google.Members(testMembersGroup) {
+		emails[m.Email] = true
+	}
+	return emails
+}
+
+func TestGroupsEmailSetAndVerify(t *testing.T) {
+	gt := newGroupsEmailTest(t, []*admin.Member{{Email: "old@example.org"}})
+
+	msg := gt.bot.startEmailVerification("user1", gt.contact, setOptions("new@example.org", ""))
+	if !strings.Contains(msg, "We sent a code") {
+		t.Fatalf("set = %q", msg)
+	}
+	code := gt.emailedCode(t, "new@example.org")
+
+	msg = gt.bot.finishEmailVerification("user1", gt.contact, code)
+	if !strings.Contains(msg, "All set") {
+		t.Fatalf("verify = %q", msg)
+	}
+	if len(gt.sf.updates) != 1 || gt.sf.updates[0][sfdc.GroupEmailField] != "new@example.org" {
+		t.Errorf("salesforce updates = %v", gt.sf.updates)
+	}
+	if emails := gt.groupEmails(); !emails["new@example.org"] || emails["old@example.org"] {
+		t.Errorf("group = %v, want the new address instead of the old one", emails)
+	}
+
+	// the code only works once
+	msg = gt.bot.finishEmailVerification("user1", gt.contact, code)
+	if !strings.Contains(msg, "no pending address change") {
+		t.Errorf("second verify = %q", msg)
+	}
+}
+
+func TestGroupsEmailDotsOnlyKeepsAddress(t *testing.T) {
+	gt := newGroupsEmailTest(t, []*admin.Member{{Email: "janedoe@gmail.com"}})
+	gt.contact.GroupEmail = "janedoe@gmail.com"
+
+	gt.bot.startEmailVerification("user1", gt.contact, setOptions("Jane.Doe@gmail.com", ""))
+	msg := gt.bot.finishEmailVerification("user1", gt.contact, gt.emailedCode(t, "Jane.Doe@gmail.com"))
+	if !strings.Contains(msg, "All set") {
+		t.Fatalf("verify = %q", msg)
+	}
+	// gmail treats these as one address, so removing the old one would remove the new one
+	if len(gt.groupEmails()) == 0 {
+		t.Error("the address was removed from the group")
+	}
+}
+
+func TestGroupsEmailAddFails(t *testing.T) {
+	gt := newGroupsEmailTest(t, []*admin.Member{{Email: "old@example.org"}})
+
+	gt.bot.startEmailVerification("user1", gt.contact, setOptions("new@example.org", ""))
+	code := gt.emailedCode(t, "new@example.org")
+	gt.google.FailNext(http.StatusBadRequest)
+	msg := gt.bot.finishEmailVerification("user1", gt.contact, code)
+	if !strings.Contains(msg, "couldn't add it") || !strings.Contains(msg, "next sync") {
+		t.Errorf("verify = %q, want a failure that says reconcile will retry", msg)
+	}
+	if emails := gt.groupEmails(); !emails["old@example.org"] {
+		t.Errorf("old address was removed even though the new one wasn't added: %v", emails)
+	}
+}
+
+func TestGroupsEmailSecondarySlot(t *testing.T) {
+	gt := newGroupsEmailTest(t, nil)
+	gt.contact.MembershipStatus = "Former"
+
+	gt.bot.startEmailVerification("user1", gt.contact, setOptions("alt@example.org", "secondary"))
+	msg := gt.bot.finishEmailVerification("user1", gt.contact, gt.emailedCode(t, "alt@example.org"))
+	if !strings.Contains(msg, "once your membership is current") {
+		t.Errorf("verify = %q", msg)
+	}
+	if len(gt.sf.updates) != 1 || gt.sf.updates[0][sfdc.GroupEmailSecondaryField] != "alt@example.org" {
+		t.Errorf("salesforce updates = %v", gt.sf.updates)
+	}
+	if len(gt.groupEmails()) != 0 {
+		t.Error("lapsed member was added to the group")
+	}
+}
+
+func TestGroupsEmailWrongCode(t *testing.T) {
+	gt := newGroupsEmailTest(t, nil)
+	gt.bot.startEmailVerification("user1", gt.contact, setOptions("new@example.org", ""))
+	code := gt.emailedCode(t, "new@example.org")
+
+	// another user can't use the code
+	other := gt.contact
+	other.ID = "003000000000002"
+	if msg := gt.bot.finishEmailVerification("user2", other, code); !strings.Contains(msg, "no pending") {
+		t.Errorf("other user's verify = %q", msg)
+	}
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < maxVerificationAttempts; i++ {
+		if msg := gt.bot.finishEmailVerification("user1", gt.contact, wrong); !strings.Contains(msg, "didn't match") {
+			t.Errorf("attempt %d = %q", i, msg)
+		}
+	}
+	// too many wrong guesses throws the code away
+	if msg := gt.bot.finishEmailVerification("user1", gt.contact, code); !strings.Contains(msg, "no pending") {
+		t.Errorf("verify after too many attempts = %q", msg)
+	}
+	if len(gt.sf.updates) != 0 {
+		t.Errorf("salesforce was updated: %v", gt.sf.updates)
+	}
+}
+
+func TestGroupsEmailRateLimit(t *testing.T) {
+	gt := newGroupsEmailTest(t, nil)
+	for i := 0; i < maxVerificationEmails; i++ {
+		msg := gt.bot.startEmailVerification("user1", gt.contact, setOptions("victim@example.org", ""))
+		if !strings.Contains(msg, "We sent a code") {
+			t.Fatalf("set %d = %q", i, msg)
+		}
+	}
+	msg := gt.bot.startEmailVerification("user1", gt.contact, setOptions("victim@example.org", ""))
+	if !strings.Contains(msg, "already sent you") {
+		t.Errorf("set over the limit = %q", msg)
+	}
+	if sent := len(gt.mail.Emails()); sent != maxVerificationEmails {
+		t.Errorf("sent %d emails, want %d", sent, maxVerificationEmails)
+	}
+
+	// the limit is per user
+	msg = gt.bot.startEmailVerification("user2", gt.contact, setOptions("someone@example.org", ""))
+	if !strings.Contains(msg, "We sent a code") {
+		t.Errorf("other user's set = %q", msg)
+	}
+}
+
+func TestGroupsEmailInvalidAddress(t *testing.T) {
+	gt := newGroupsEmailTest(t, nil)
+	msg := gt.bot.startEmailVerification("user1", gt.contact, setOptions("Jane <jane@example.org>", ""))
+	if !strings.Contains(msg, "doesn't look like an email") {
+		t.Errorf("set = %q", msg)
+	}
+	if len(gt.mail.Emails()) != 0 {
+		t.Error("sent a code to an invalid address")
+	}
+}
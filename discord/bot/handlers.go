@@ -1,11 +1,15 @@
 package bot
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/theforgeinitiative/integrations/mail"
+	"github.com/theforgeinitiative/integrations/metrics"
+	"github.com/theforgeinitiative/integrations/mq"
 )
 
 func (b *Bot) interactionHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -19,6 +23,22 @@ func (b *Bot) interactionHandler(s *discordgo.Session, i *discordgo.InteractionC
 			b.letmeinHandler(s, i)
 			return
 		}
+		if i.ApplicationCommandData().Name == "unlock-door" {
+			b.unlockDoorHandler(s, i)
+			return
+		}
+		if i.ApplicationCommandData().Name == "my-visits" {
+			b.myVisitsHandler(s, i)
+			return
+		}
+		if i.ApplicationCommandData().Name == "groups-email" {
+			b.groupsEmailHandler(s, i)
+			return
+		}
+		if i.ApplicationCommandData().Name == "sync" {
+			b.syncHandler(s, i)
+			return
+		}
 		if h, ok := commandsHandlers[i.ApplicationCommandData().Name]; ok {
 			h(s, i)
 		}
@@ -219,12 +239,17 @@ func (b *Bot) unlockStorageHandler(s *discordgo.Session, i *discordgo.Interactio
 		return
 	}
 	log.Printf("%s generated a storage unlock code", fullName)
+	metrics.UnlockCodes.WithLabelValues(lock).Inc()
 
 	// TODO: Google Sheet stuff
 	err = b.SheetLog.StorageLog(contact, lock)
 	if err != nil {
 		log.Printf("Error logging storage code retrieval: %s", err)
 	}
+	err = b.MQClient.PublishStorageCodeIssued(contact, lock, endDate)
+	if err != nil {
+		log.Printf("Failed to publish storage code event: %s", err)
+	}
 
 	// pretty format the code
 	if len(code) == 9 {
@@ -296,8 +321,12 @@ func (b *Bot) requestStorageHandler(s *discordgo.Session, i *discordgo.Interacti
 	}
 
 	approvalLink := fmt.Sprintf(b.IglooHomeClient.ApprovalLink, cm.StringField("Id"))
-	msg := fmt.Sprintf("%s %s has requested access to the storage units.\nEmail: %s\n\nReview in Salesforce: %s", contact.FirstName, contact.LastName, contact.Email, approvalLink)
-	err = b.MailClient.SendMail("Storage Unit Access Request", b.IglooHomeClient.ApprovalEmail, msg)
+	err = b.MailClient.Send(mail.Recipients{To: []string{b.IglooHomeClient.ApprovalEmail}, ReplyTo: contact.Email}, mail.StorageRequest{
+		FirstName:    contact.FirstName,
+		LastName:     contact.LastName,
+		Email:        contact.Email,
+		ApprovalLink: approvalLink,
+	})
 	if err != nil {
 		log.Printf("Failed to send approval email for %s: %s", contact.DisplayName, err)
 	}
@@ -330,11 +359,15 @@ func (b *Bot) letmeinHandler(s *discordgo.Session, i *discordgo.InteractionCreat
 	if err != nil {
 		log.Printf("Failed to publish doorbell message: %s", err)
 		msg := ":woozy_face: Oof! I encountered a problem requesting access. Please try again, but worst case you may have to ask someone to let you in the old fashioned way."
+		if errors.Is(err, mq.ErrNotConnected) {
+			msg = ":electric_plug: I can't reach the doorbell right now, so nobody would hear it ring. You'll have to ask someone to let you in the old fashioned way."
+		}
 		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
 			Content: &msg,
 		})
 		return
 	}
+	metrics.DoorbellRings.WithLabelValues(door).Inc()
 	followup := "I rang the bell for you! Sit tight... :person_running_facing_right:"
 	s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
 		Content: &followup,
@@ -350,6 +383,143 @@ func (b *Bot) letmeinHandler(s *discordgo.Session, i *discordgo.InteractionCreat
 	log.Printf("Rang the %s doorbell for %s", door, memberDisplayName(i.Member))
 }
 
+func (b *Bot) unlockDoorHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Checking your membership... :thinking:",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	var uid string
+	if i.Member != nil {
+		uid = i.Member.User.ID
+	} else {
+		uid = i.User.ID
+	}
+	contact, err := b.SFClient.GetContactByDiscordID(uid)
+	if err != nil {
+		log.Printf("Failed to lookup member when unlocking door: %s", err)
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: ":woozy_face: Oof! We encountered a problem unlocking the door. Please ensure you've linked your membership to your Discord account and try again.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+
+	if !contact.CurrentMember() {
+		log.Printf("%s tried to unlock a door, but was not a current member", contact.DisplayName)
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: ":customs: You must be a current member to unlock TFI doors. Try `/letmein` to ring the bell instead.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+
+	doorID := i.ApplicationCommandData().Options[0].StringValue()
+	door, ok := b.MQClient.Door(doorID)
+	if !ok {
+		log.Printf("%s tried to unlock unknown door %s", contact.DisplayName, doorID)
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: ":woozy_face: I don't know about that door. Please ask for help if you're stuck.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+
+	if !door.Allowed(time.Now()) {
+		log.Printf("%s tried to unlock the %s door outside of allowed hours", contact.DisplayName, door.ID)
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: fmt.Sprintf(":clock3: The %s can only be unlocked remotely between %d:00 and %d:00. Try `/letmein` to ring the bell instead.", door.Label, door.OpenHour, door.CloseHour),
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+
+	err = b.MQClient.UnlockDoor(door.ID)
+	if err != nil {
+		log.Printf("Failed to publish unlock message: %s", err)
+		msg := ":woozy_face: Oof! We encountered a problem unlocking the door. Please try again, but worst case you may have to `/letmein` the old fashioned way."
+		if errors.Is(err, mq.ErrNotConnected) {
+			msg = ":electric_plug: I can't reach the door controller right now. You'll have to ask someone to let you in the old fashioned way."
+		}
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: msg,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+	log.Printf("%s unlocked the %s door", contact.DisplayName, door.ID)
+
+	err = b.DoorLog.DoorLog(contact, door.ID)
+	if err != nil {
+		log.Printf("Error logging door unlock: %s", err)
+	}
+
+	s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: fmt.Sprintf(":unlock: The %s is unlocked. Come on in!", door.Label),
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+}
+
+func (b *Bot) myVisitsHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Counting your visits... :abacus:",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	var uid string
+	if i.Member != nil {
+		uid = i.Member.User.ID
+	} else {
+		uid = i.User.ID
+	}
+	contact, err := b.SFClient.GetContactByDiscordID(uid)
+	if err != nil {
+		log.Printf("Failed to lookup member when counting visits: %s", err)
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: ":woozy_face: Oof! We encountered a problem looking up your visits. Please ensure you've linked your membership to your Discord account and try again.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+
+	days := defaultVisitDays
+	if opts := i.ApplicationCommandData().Options; len(opts) > 0 {
+		days = int(opts[0].IntValue())
+	}
+	summaries, err := b.VisitCache.Summaries(days)
+	if err != nil {
+		log.Printf("Failed to retrieve checkmein visits: %s", err)
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: ":woozy_face: Oof! We encountered a problem looking up your visits. Please try again and ask for help if you're stuck.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+
+	summary, ok := summaries[contact.Barcode]
+	if !ok {
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: fmt.Sprintf(":ghost: I don't see any visits in the last %d days. Don't forget to check in next time you're at the shop!", days),
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+
+	msg := fmt.Sprintf(":bar_chart: You've visited **%d** times in the last %d days", summary.Count, days)
+	if summary.Duration > 0 {
+		msg += fmt.Sprintf(", spending about **%.1f hours** at the shop", summary.Duration.Hours())
+	}
+	msg += fmt.Sprintf(".\nYour last visit was on **%s**.", summary.LastVisit.Format("Mon, Jan 2"))
+	s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: msg,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+}
+
 func (b *Bot) sendDM(uid, msg string) error {
 	ch, err := b.Session.UserChannelCreate(uid)
 	if err != nil {
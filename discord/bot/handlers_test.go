@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/theforgeinitiative/integrations/discord"
+	"github.com/theforgeinitiative/integrations/mq"
+	"github.com/theforgeinitiative/integrations/mq/mqtest"
+)
+
+type discordRequest struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+// fakeDiscord records REST calls made through a session instead of sending
+// them to Discord
+type fakeDiscord struct {
+	mu       sync.Mutex
+	requests []discordRequest
+}
+
+func (f *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var decoded map[string]interface{}
+	json.Unmarshal(body, &decoded)
+	f.mu.Lock()
+	f.requests = append(f.requests, discordRequest{Method: r.Method, Path: r.URL.Path, Body: decoded})
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"id":"1"}`))
+}
+
+func (f *fakeDiscord) find(method, pathSuffix string) (discordRequest, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.requests {
+		if r.Method == method && strings.HasSuffix(r.Path, pathSuffix) {
+			return r, true
+		}
+	}
+	return discordRequest{}, false
+}
+
+// rewriteTransport sends every request to the fake server, keeping the path
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func newTestSession(t *testing.T) (*discordgo.Session, *fakeDiscord) {
+	t.Helper()
+	fake := &fakeDiscord{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+
+	s, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatalf("failed to create session: %s", err)
+	}
+	s.Client = &http.Client{Transport: rewriteTransport{target: target}}
+	return s, fake
+}
+
+func letmeinInteraction(door string) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:      "100",
+		AppID:   "200",
+		Token:   "interaction-token",
+		Type:    discordgo.InteractionApplicationCommand,
+		GuildID: "guild1",
+		Member:  &discordgo.Member{User: &discordgo.User{ID: "user1"}},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name: "letmein",
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "door", Type: discordgo.ApplicationCommandOptionString, Value: door},
+			},
+		},
+	}}
+}
+
+func TestLetmeinHandler(t *testing.T) {
+	broker, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatalf("failed to start broker: %s", err)
+	}
+	defer broker.Close()
+	mqc, err := mq.NewClient(mq.Config{Broker: broker.URL, ClientID: "bot-test"})
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer mqc.Close()
+
+	s, fake := newTestSession(t)
+	b := &Bot{
+		MQClient: mqc,
+		Guilds: map[string]discord.Guild{
+			"tfi": {ID: "guild1", DoorbellChannelID: "doorbell-channel"},
+		},
+	}
+
+	b.letmeinHandler(s, letmeinInteraction("loft"))
+
+	msg, err := broker.WaitForMessage("door/loft", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Payload) != "ring" {
+		t.Errorf("payload = %q, want %q", msg.Payload, "ring")
+	}
+
+	edit, ok := fake.find(http.MethodPatch, "/webhooks/200/interaction-token/messages/@original")
+	if !ok {
+		t.Fatal("interaction response was not edited")
+	}
+	if content, _ := edit.Body["content"].(string); !strings.Contains(content, "I rang the bell") {
+		t.Errorf("edited response = %q, want confirmation", content)
+	}
+	announce, ok := fake.find(http.MethodPost, "/channels/doorbell-channel/messages")
+	if !ok {
+		t.Fatal("doorbell channel was not notified")
+	}
+	if content, _ := announce.Body["content"].(string); !strings.Contains(content, "<@user1>") || !strings.Contains(content, "loft") {
+		t.Errorf("doorbell message = %q, want mention of user and door", content)
+	}
+}
+
+func TestLetmeinHandlerBrokerDown(t *testing.T) {
+	broker, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatalf("failed to start broker: %s", err)
+	}
+	mqc, err := mq.NewClient(mq.Config{Broker: broker.URL, ClientID: "bot-test"})
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer mqc.Close()
+	broker.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for mqc.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("client still connected after broker closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s, fake := newTestSession(t)
+	b := &Bot{
+		MQClient: mqc,
+		Guilds: map[string]discord.Guild{
+			"tfi": {ID: "guild1", DoorbellChannelID: "doorbell-channel"},
+		},
+	}
+
+	b.letmeinHandler(s, letmeinInteraction("loft"))
+
+	edit, ok := fake.find(http.MethodPatch, "/messages/@original")
+	if !ok {
+		t.Fatal("interaction response was not edited")
+	}
+	if content, _ := edit.Body["content"].(string); !strings.Contains(content, "can't reach the doorbell") {
+		t.Errorf("edited response = %q, want broker down message", content)
+	}
+	if _, ok := fake.find(http.MethodPost, "/channels/doorbell-channel/messages"); ok {
+		t.Error("doorbell channel notified even though the bell didn't ring")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/theforgeinitiative/integrations/metrics"
 )
 
 func (b *Bot) linkMembershipHadler(s *discordgo.Session, i *discordgo.InteractionCreate) error {
@@ -63,6 +64,7 @@ func (b *Bot) linkMembershipHadler(s *discordgo.Session, i *discordgo.Interactio
 		})
 		return fmt.Errorf("failed to send follow-up response: %s", err)
 	}
+	metrics.LinksCompleted.Inc()
 
 	// Set role
 	for gName, guild := range b.Guilds {
@@ -84,6 +86,12 @@ func (b *Bot) linkMembershipHadler(s *discordgo.Session, i *discordgo.Interactio
 		}
 	}
 
+	contact.DiscordID = i.Member.User.ID
+	err = b.MQClient.PublishMemberLinked(contact)
+	if err != nil {
+		log.Printf("Failed to publish member linked event for %s: %s", contact.DisplayName, err)
+	}
+
 	_, err = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
 		Content: ":tada: You're all set! If you didn't already have access, check out all the new member areas.",
 		Flags:   discordgo.MessageFlagsEphemeral,
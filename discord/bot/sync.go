@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+var moderatorPermissions int64 = discordgo.PermissionModerateMembers
+
+var syncDMPermission = false
+
+var syncCommand = discordgo.ApplicationCommand{
+	Name:                     "sync",
+	Description:              "Fix a member's group, Discord role and CheckMeIn access right now",
+	DefaultMemberPermissions: &moderatorPermissions,
+	DMPermission:             &syncDMPermission,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Name:        "user",
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Required:    true,
+			Description: "Who needs fixing",
+		},
+	},
+}
+
+func (b *Bot) syncHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Syncing... :arrows_counterclockwise:",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	user := i.ApplicationCommandData().Options[0].UserValue(s)
+
+	contact, err := b.SFClient.GetContactByDiscordID(user.ID)
+	if errors.Is(err, sfdc.ErrContactNotFound) {
+		// nothing linked, so the only thing to fix is a leftover member role
+		contact = sfdc.Contact{DiscordID: user.ID}
+		err = nil
+	}
+	if err != nil {
+		log.Printf("Failed to lookup %s for sync: %s", user.ID, err)
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: ":woozy_face: Oof! We encountered a problem looking up that member. Please try again later.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+
+	result, err := b.Reconciler.RunContacts([]sfdc.Contact{contact}, false)
+	if err != nil {
+		log.Printf("Failed to sync %s: %s", user.ID, err)
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: ":woozy_face: Oof! Something went wrong while syncing. Please try again later.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		return
+	}
+
+	var by string
+	if i.Member != nil {
+		by = i.Member.User.ID
+	}
+	log.Printf("%s synced %s (%s)", by, user.ID, contact.ID)
+
+	msg := fmt.Sprintf("<@%s> is already up to date.", user.ID)
+	if result.Report.HasChanges() {
+		msg = fmt.Sprintf("Synced <@%s>.", user.ID)
+	}
+	if len(contact.ID) == 0 {
+		msg += " They haven't linked their membership yet."
+	}
+	s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content:         msg,
+		Embeds:          []*discordgo.MessageEmbed{result.Report.DiscordEmbed()},
+		Flags:           discordgo.MessageFlagsEphemeral,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+}
@@ -1,9 +1,13 @@
 package discord
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/theforgeinitiative/integrations/metrics"
 )
 
 type Client struct {
@@ -16,6 +20,7 @@ type Guild struct {
 	MemberRoleID      string `mapstructure:"memberRole"`
 	WelcomeChannelID  string `mapstructure:"welcomeChannel"`
 	DoorbellChannelID string `mapstructure:"doorbellChannel"`
+	AdminChannelID    string `mapstructure:"adminChannel"`
 }
 
 type Member struct {
@@ -44,12 +49,41 @@ func NewClient(token string) (*Client, error) {
 	}, err
 }
 
+// Ping checks the bot token against the REST API
+func (c *Client) Ping() error {
+	_, err := c.BotSession.User("@me")
+	if err != nil {
+		return fmt.Errorf("failed to get bot user: %w", err)
+	}
+	return nil
+}
+
+// gateway heartbeats are every 40 seconds or so
+const maxHeartbeatAge = 2 * time.Minute
+
+// CheckGateway fails unless the websocket is open and heartbeats are being acked
+func (c *Client) CheckGateway() error {
+	c.BotSession.RLock()
+	ready := c.BotSession.DataReady
+	lastAck := c.BotSession.LastHeartbeatAck
+	c.BotSession.RUnlock()
+	if !ready {
+		return errors.New("discord gateway isn't connected")
+	}
+	if time.Since(lastAck) > maxHeartbeatAge {
+		return fmt.Errorf("no discord heartbeat ack since %s", lastAck.Format(time.RFC3339))
+	}
+	return nil
+}
+
 func (c *Client) GuildMembers() (map[string]map[string]Member, error) {
 	members := make(map[string]map[string]Member)
 	for name, guild := range c.Guilds {
 		members[name] = make(map[string]Member)
 		// TODO: actually paginate this if we have over 1000 members
+		done := metrics.Track("discord", "guild_members")
 		guildMembers, err := c.BotSession.GuildMembers(guild.ID, "", 1000)
+		done(err)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get guild members for %s: %s", name, err)
 		}
@@ -68,12 +102,41 @@ func (c *Client) GuildMembers() (map[string]map[string]Member, error) {
 	return members, nil
 }
 
+// GuildMember looks up one user in a configured guild. ok is false if they aren't in it.
+func (c *Client) GuildMember(guildName, userID string) (Member, bool, error) {
+	guild, ok := c.Guilds[guildName]
+	if !ok {
+		return Member{}, false, fmt.Errorf("guild name %s not configured", guildName)
+	}
+	done := metrics.Track("discord", "guild_member")
+	gm, err := c.BotSession.GuildMember(guild.ID, userID)
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound {
+		done(nil)
+		return Member{}, false, nil
+	}
+	done(err)
+	if err != nil {
+		return Member{}, false, fmt.Errorf("failed to get guild member %s in %s: %w", userID, guildName, err)
+	}
+	return Member{
+		ID:         gm.User.ID,
+		ServerNick: gm.Nick,
+		Username:   gm.User.Username,
+		GlobalName: gm.User.GlobalName,
+		GuildID:    guild.ID,
+		Roles:      gm.Roles,
+	}, true, nil
+}
+
 func (c *Client) AddMemberRole(userID, guildName string) error {
 	guild, ok := c.Guilds[guildName]
 	if !ok {
 		return fmt.Errorf("guild name %s not configured", guildName)
 	}
+	done := metrics.Track("discord", "add_role")
 	err := c.BotSession.GuildMemberRoleAdd(guild.ID, userID, guild.MemberRoleID)
+	done(err)
 	if err != nil {
 		return fmt.Errorf("failed to add user %s to guild %s member role: %w", userID, guildName, err)
 	}
@@ -86,7 +149,9 @@ func (c *Client) RemoveMemberRole(userID, guildName string) error {
 	if !ok {
 		return fmt.Errorf("guild name %s not configured", guildName)
 	}
+	done := metrics.Track("discord", "remove_role")
 	err := c.BotSession.GuildMemberRoleRemove(guild.ID, userID, guild.MemberRoleID)
+	done(err)
 	if err != nil {
 		return fmt.Errorf("failed to remove user %s from guild %s member role: %w", userID, guildName, err)
 	}
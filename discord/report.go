@@ -0,0 +1,29 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/theforgeinitiative/integrations/metrics"
+)
+
+// PostAdminEmbed sends an embed to the admin channel of every guild that has one
+func (c *Client) PostAdminEmbed(embed *discordgo.MessageEmbed) error {
+	var failed []string
+	for name, guild := range c.Guilds {
+		if len(guild.AdminChannelID) == 0 {
+			continue
+		}
+		done := metrics.Track("discord", "send_message")
+		_, err := c.BotSession.ChannelMessageSendEmbed(guild.AdminChannelID, embed)
+		done(err)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to post to admin channels: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package googletest
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+)
+
+// batch splits a multipart/mixed request into its parts and answers each one
+// as if it had been sent on its own
+func (s *Server) batch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "methodNotAllowed", "method not allowed")
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		writeError(w, http.StatusBadRequest, "invalid", "batch requests must be multipart/mixed")
+		return
+	}
+
+	type result struct {
+		id   string
+		resp *http.Response
+	}
+	var results []result
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		req, err := http.ReadRequest(bufio.NewReader(part))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", "invalid batch part: "+err.Error())
+			return
+		}
+		rec := httptest.NewRecorder()
+		s.serve(rec, req)
+		results = append(results, result{id: part.Header.Get("Content-Id"), resp: rec.Result()})
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	for _, res := range results {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-Id":   {fmt.Sprintf("<response-%s>", strings.Trim(res.id, "<>"))},
+		})
+		if err != nil {
+			return
+		}
+		res.resp.Write(part)
+	}
+	mw.Close()
+}
@@ -0,0 +1,356 @@
+// Package googletest provides a stand-in for the parts of the Admin Directory
+// and Sheets APIs used by the groups and sheetlog packages, so they can be
+// exercised without Google credentials.
+package googletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+const membersPrefix = "/admin/directory/v1/groups/"
+const batchPath = "/batch/admin/directory_v1"
+const valuesPrefix = "/v4/spreadsheets/"
+
+// the Admin SDK caps list pages at 200
+const maxPageSize = 200
+
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	groups   map[string]map[string]*admin.Member
+	sheets   map[string]map[string][][]interface{}
+	failures []int
+	nextID   int
+}
+
+// NewServer starts an empty server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		groups: make(map[string]map[string]*admin.Member),
+		sheets: make(map[string]map[string][][]interface{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// ClientOptions points a Google API client at the server
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.URL + "/"),
+		option.WithoutAuthentication(),
+	}
+}
+
+// SetMembers creates the group if needed and replaces its members. Empty
+// roles and delivery settings get the API defaults.
+func (s *Server) SetMembers(group string, members []*admin.Member) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := make(map[string]*admin.Member, len(members))
+	for _, m := range members {
+		g[memberKey(m.Email)] = s.newMember(m)
+	}
+	s.groups[strings.ToLower(group)] = g
+}
+
+// memberKey matches members like Google does: case-insensitively, and
+// ignoring dots in gmail addresses
+func memberKey(email string) string {
+	email = strings.ToLower(email)
+	local, domain, ok := strings.Cut(email, "@")
+	if ok && (domain == "gmail.com" || domain == "googlemail.com") {
+		return strings.ReplaceAll(local, ".", "") + "@" + domain
+	}
+	return email
+}
+
+// Members returns a group's members sorted by email
+func (s *Server) Members(group string) []*admin.Member {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedMembers(s.groups[strings.ToLower(group)])
+}
+
+// Rows returns everything appended to a sheet, in order
+func (s *Server) Rows(spreadsheetID, sheet string) [][]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]interface{}(nil), s.sheets[spreadsheetID][sheet]...)
+}
+
+// FailNext makes the next Directory requests fail with the given statuses, one
+// per request. 403 fails with rateLimitExceeded, like the real quota errors.
+func (s *Server) FailNext(codes ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, codes...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == batchPath:
+		s.batch(w, r)
+	case strings.HasPrefix(r.URL.Path, membersPrefix):
+		if code, ok := s.nextFailure(); ok {
+			reason := "backendError"
+			if code == http.StatusForbidden {
+				reason = "rateLimitExceeded"
+			}
+			writeError(w, code, reason, http.StatusText(code))
+			return
+		}
+		s.members(w, r)
+	case strings.HasPrefix(r.URL.Path, valuesPrefix):
+		s.values(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path "+r.URL.Path)
+	}
+}
+
+func (s *Server) nextFailure() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failures) == 0 {
+		return 0, false
+	}
+	code := s.failures[0]
+	s.failures = s.failures[1:]
+	return code, true
+}
+
+// members handles groups/{group}/members[/{member}]
+func (s *Server) members(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, membersPrefix), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "members" {
+		writeError(w, http.StatusNotFound, "notFound", "unknown path "+r.URL.Path)
+		return
+	}
+	group := strings.ToLower(parts[0])
+	var key string
+	if len(parts) == 3 {
+		key = memberKey(parts[2])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[group]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Resource Not Found: groupKey")
+		return
+	}
+
+	switch {
+	case len(key) == 0 && r.Method == http.MethodGet:
+		s.listMembers(w, r, g)
+	case len(key) == 0 && r.Method == http.MethodPost:
+		var m admin.Member
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil || len(m.Email) == 0 {
+			writeError(w, http.StatusBadRequest, "invalid", "Missing required field: member")
+			return
+		}
+		if _, ok := g[memberKey(m.Email)]; ok {
+			writeError(w, http.StatusConflict, "duplicate", "Member already exists.")
+			return
+		}
+		member := s.newMember(&m)
+		g[memberKey(m.Email)] = member
+		writeJSON(w, member)
+	case r.Method == http.MethodGet, r.Method == http.MethodPatch, r.Method == http.MethodDelete:
+		member, ok := g[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "Resource Not Found: memberKey")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, member)
+		case http.MethodPatch:
+			var m admin.Member
+			if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid", "Invalid member")
+				return
+			}
+			if len(m.Role) > 0 {
+				member.Role = m.Role
+			}
+			if len(m.DeliverySettings) > 0 {
+				member.DeliverySettings = m.DeliverySettings
+			}
+			writeJSON(w, member)
+		case http.MethodDelete:
+			delete(g, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "methodNotAllowed", "method not allowed")
+	}
+}
+
+// page tokens are just offsets into the sorted member list
+func (s *Server) listMembers(w http.ResponseWriter, r *http.Request, g map[string]*admin.Member) {
+	size := maxPageSize
+	if v := r.URL.Query().Get("maxResults"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid", "Invalid maxResults")
+			return
+		}
+		if n < size {
+			size = n
+		}
+	}
+	start := 0
+	if v := r.URL.Query().Get("pageToken"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid", "Invalid pageToken")
+			return
+		}
+		start = n
+	}
+
+	members := sortedMembers(g)
+	resp := admin.Members{Kind: "admin#directory#members"}
+	if start < len(members) {
+		end := start + size
+		if end < len(members) {
+			resp.NextPageToken = strconv.Itoa(end)
+		} else {
+			end = len(members)
+		}
+		resp.Members = members[start:end]
+	}
+	writeJSON(w, resp)
+}
+
+// newMember fills in what the API would. Callers hold mu.
+func (s *Server) newMember(m *admin.Member) *admin.Member {
+	s.nextID++
+	member := &admin.Member{
+		Kind:             "admin#directory#member",
+		Id:               fmt.Sprintf("%021d", s.nextID),
+		Email:            m.Email,
+		Role:             m.Role,
+		DeliverySettings: m.DeliverySettings,
+		Status:           "ACTIVE",
+		Type:             "USER",
+	}
+	if len(member.Role) == 0 {
+		member.Role = "MEMBER"
+	}
+	if len(member.DeliverySettings) == 0 {
+		member.DeliverySettings = "ALL_MAIL"
+	}
+	return member
+}
+
+// values handles spreadsheets/{id}/values/{range} and its :append variant
+func (s *Server) values(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, valuesPrefix), "/", 3)
+	if len(parts) != 3 || parts[1] != "values" {
+		writeError(w, http.StatusNotFound, "notFound", "unknown path "+r.URL.Path)
+		return
+	}
+	id := parts[0]
+	rng := parts[2]
+	appending := strings.HasSuffix(rng, ":append")
+	rng = strings.TrimSuffix(rng, ":append")
+	// only whole sheets are supported, so any A1 part of the range is ignored
+	sheet := strings.Trim(strings.SplitN(rng, "!", 2)[0], "'")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case appending && r.Method == http.MethodPost:
+		var vr sheets.ValueRange
+		if err := json.NewDecoder(r.Body).Decode(&vr); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", "Invalid value range")
+			return
+		}
+		if s.sheets[id] == nil {
+			s.sheets[id] = make(map[string][][]interface{})
+		}
+		first := len(s.sheets[id][sheet]) + 1
+		s.sheets[id][sheet] = append(s.sheets[id][sheet], vr.Values...)
+		updated := fmt.Sprintf("%s!A%d:%s%d", sheet, first, columnName(maxWidth(vr.Values)), first+len(vr.Values)-1)
+		writeJSON(w, sheets.AppendValuesResponse{
+			SpreadsheetId: id,
+			Updates: &sheets.UpdateValuesResponse{
+				SpreadsheetId: id,
+				UpdatedRange:  updated,
+				UpdatedRows:   int64(len(vr.Values)),
+			},
+		})
+	case !appending && r.Method == http.MethodGet:
+		writeJSON(w, sheets.ValueRange{
+			Range:          sheet,
+			MajorDimension: "ROWS",
+			Values:         s.sheets[id][sheet],
+		})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "methodNotAllowed", "method not allowed")
+	}
+}
+
+func sortedMembers(g map[string]*admin.Member) []*admin.Member {
+	members := make([]*admin.Member, 0, len(g))
+	for _, m := range g {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Email < members[j].Email })
+	return members
+}
+
+func maxWidth(rows [][]interface{}) int {
+	width := 1
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	return width
+}
+
+// columnName converts a 1-based column number to A1 notation
+func columnName(n int) string {
+	var name string
+	for n > 0 {
+		n--
+		name = string(rune('A'+n%26)) + name
+		n /= 26
+	}
+	return name
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError uses the error body googleapi.CheckResponse expects
+func writeError(w http.ResponseWriter, code int, reason, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"errors": []map[string]string{
+				{"domain": "global", "reason": reason, "message": message},
+			},
+		},
+	})
+}
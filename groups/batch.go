@@ -0,0 +1,221 @@
+package groups
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/metrics"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Admin SDK allows up to 1000, but smaller batches spread out quota usage
+const maxBatchSize = 100
+
+const batchPath = "batch/admin/directory_v1"
+const membersPathPattern = "/admin/directory/v1/groups/%s/members"
+
+type Member struct {
+	Email    string
+	Role     string
+	Delivery string
+}
+
+type batchCall struct {
+	key    string
+	method string
+	path   string
+	body   []byte
+	// maps errors that mean the member is already in the desired state to nil
+	normalize func(error) error
+}
+
+// AddMembers inserts members using batch requests. Errors are keyed by email
+// and only present for members that couldn't be added.
+func (c *Client) AddMembers(members []Member) map[string]error {
+	calls := make([]batchCall, 0, len(members))
+	for _, m := range members {
+		body, _ := json.Marshal(admin.Member{Email: m.Email, Role: m.Role, DeliverySettings: m.Delivery})
+		calls = append(calls, batchCall{
+			key:       m.Email,
+			method:    http.MethodPost,
+			path:      c.membersPath(""),
+			body:      body,
+			normalize: ignoreAlreadyMember,
+		})
+	}
+	return c.runBatches(calls)
+}
+
+// UpdateMembers patches role and delivery settings. Empty values are left unchanged.
+func (c *Client) UpdateMembers(members []Member) map[string]error {
+	calls := make([]batchCall, 0, len(members))
+	for _, m := range members {
+		body, _ := json.Marshal(admin.Member{Role: m.Role, DeliverySettings: m.Delivery})
+		calls = append(calls, batchCall{
+			key:       m.Email,
+			method:    http.MethodPatch,
+			path:      c.membersPath(m.Email),
+			body:      body,
+			normalize: func(err error) error { return err },
+		})
+	}
+	return c.runBatches(calls)
+}
+
+// RemoveMembers deletes members using batch requests. Only Email is used.
+func (c *Client) RemoveMembers(members []Member) map[string]error {
+	calls := make([]batchCall, 0, len(members))
+	for _, m := range members {
+		calls = append(calls, batchCall{
+			key:       m.Email,
+			method:    http.MethodDelete,
+			path:      c.membersPath(m.Email),
+			normalize: ignoreNotFound,
+		})
+	}
+	return c.runBatches(calls)
+}
+
+func (c *Client) membersPath(email string) string {
+	p := fmt.Sprintf(membersPathPattern, url.PathEscape(c.Group))
+	if len(email) > 0 {
+		p += "/" + url.PathEscape(email)
+	}
+	return p
+}
+
+// runBatches sends calls in chunks, retrying the calls that were rate limited
+// or hit server errors with exponential backoff
+func (c *Client) runBatches(calls []batchCall) map[string]error {
+	errs := make(map[string]error)
+	for start := 0; start < len(calls); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(calls) {
+			end = len(calls)
+		}
+
+		pending := calls[start:end]
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt > 0 {
+				time.Sleep(backoff(attempt - 1))
+			}
+			done := metrics.Track("groups", "batch")
+			results, err := c.sendBatch(pending)
+			done(err)
+			var retry []batchCall
+			for i, call := range pending {
+				callErr := err
+				if err == nil {
+					callErr = call.normalize(results[i])
+				}
+				if retryable(callErr) && attempt+1 < maxAttempts {
+					retry = append(retry, call)
+					continue
+				}
+				if callErr != nil {
+					errs[call.key] = callErr
+				} else {
+					delete(errs, call.key)
+				}
+			}
+			pending = retry
+		}
+	}
+	return errs
+}
+
+// sendBatch returns one result per call, in order. The error is only set if
+// the batch as a whole failed.
+func (c *Client) sendBatch(calls []batchCall) ([]error, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for i, call := range calls {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-Id":   {fmt.Sprintf("<item%d>", i)},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to build batch part: %w", err)
+		}
+		fmt.Fprintf(part, "%s %s HTTP/1.1\r\n", call.method, call.path)
+		if len(call.body) > 0 {
+			fmt.Fprintf(part, "Content-Type: application/json\r\nContent-Length: %d\r\n\r\n", len(call.body))
+			part.Write(call.body)
+		} else {
+			fmt.Fprint(part, "\r\n")
+		}
+	}
+	w.Close()
+
+	req, err := http.NewRequest(http.MethodPost, c.batchURL, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build batch request: %w", err)
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+w.Boundary())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send batch request: %w", err)
+	}
+	defer resp.Body.Close()
+	err = googleapi.CheckResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse batch response type: %w", err)
+	}
+
+	results := make([]error, len(calls))
+	seen := make([]bool, len(calls))
+	r := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch response: %w", err)
+		}
+		i, ok := responseIndex(part.Header.Get("Content-Id"))
+		if !ok || i >= len(calls) {
+			continue
+		}
+		partResp, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse batch response part: %w", err)
+		}
+		results[i] = googleapi.CheckResponse(partResp)
+		partResp.Body.Close()
+		seen[i] = true
+	}
+
+	for i := range calls {
+		if !seen[i] {
+			results[i] = &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "missing from batch response"}
+		}
+	}
+	return results, nil
+}
+
+// responses are labelled <response-itemN>
+func responseIndex(contentID string) (int, bool) {
+	id := strings.Trim(contentID, "<>")
+	id = strings.TrimPrefix(id, "response-")
+	i, err := strconv.Atoi(strings.TrimPrefix(id, "item"))
+	return i, err == nil
+}
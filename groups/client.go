@@ -3,34 +3,107 @@ package groups
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strings"
 
+	"github.com/theforgeinitiative/integrations/metrics"
 	admin "google.golang.org/api/admin/directory/v1"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
+const (
+	RoleMember  = "MEMBER"
+	RoleManager = "MANAGER"
+	RoleOwner   = "OWNER"
+)
+
+const (
+	DeliveryAllMail = "ALL_MAIL"
+	DeliveryDigest  = "DIGEST"
+	DeliveryDaily   = "DAILY"
+	DeliveryNone    = "NONE"
+)
+
+// roles ranked by privilege
+var roleRank = map[string]int{
+	RoleMember:  1,
+	RoleManager: 2,
+	RoleOwner:   3,
+}
+
+// HigherRole returns whichever role grants more privileges
+func HigherRole(a, b string) string {
+	if roleRank[b] > roleRank[a] {
+		return b
+	}
+	return a
+}
+
 type Client struct {
 	adminSvc *admin.Service
 	Group    string
+
+	// the service's client, reused for batch requests
+	httpClient *http.Client
+	batchURL   string
 }
 
-func NewClient(group string) (Client, error) {
-	adminSvc, err := admin.NewService(context.TODO(), option.WithScopes("https://www.googleapis.com/auth/admin.directory.group.member"))
+// NewClient uses application default credentials against the production Admin
+// SDK. Options are applied on top, e.g. option.WithEndpoint for a fake server.
+func NewClient(group string, opts ...option.ClientOption) (Client, error) {
+	ctx := context.TODO()
+	opts = append([]option.ClientOption{option.WithScopes("https://www.googleapis.com/auth/admin.directory.group.member")}, opts...)
+	hc, _, err := htransport.NewClient(ctx, opts...)
+	if err != nil {
+		return Client{}, fmt.Errorf("failed to create admin http client: %w", err)
+	}
+	adminSvc, err := admin.NewService(ctx, append(opts, option.WithHTTPClient(hc))...)
 	if err != nil {
 		return Client{}, fmt.Errorf("failed to create admin service: %w", err)
 	}
 
-	return Client{adminSvc: adminSvc, Group: group}, nil
+	// batch requests go to the same host as everything else, including overrides
+	return Client{
+		adminSvc:   adminSvc,
+		Group:      group,
+		httpClient: hc,
+		batchURL:   strings.TrimSuffix(adminSvc.BasePath, "/") + "/" + batchPath,
+	}, nil
+}
+
+// Ping lists a single member to check credentials and connectivity
+func (c *Client) Ping() error {
+	_, err := c.adminSvc.Members.List(c.Group).MaxResults(1).Do()
+	if err != nil {
+		return fmt.Errorf("failed to list members of %s: %w", c.Group, err)
+	}
+	return nil
 }
 
 func (c *Client) LookupMember(email string) (*admin.Member, error) {
-	return c.adminSvc.Members.Get(c.Group, email).Do()
+	var m *admin.Member
+	done := metrics.Track("groups", "get_member")
+	err := withRetry(func() (err error) {
+		m, err = c.adminSvc.Members.Get(c.Group, email).Do()
+		return err
+	})
+	// not being a member is an answer, not a failure
+	done(ignoreNotFound(err))
+	return m, err
 }
 
 func (c *Client) ListMembers() ([]*admin.Member, error) {
 	var memberList []*admin.Member
 	pageToken := ""
 	for {
-		listResp, err := c.adminSvc.Members.List(c.Group).MaxResults(200).PageToken(pageToken).Do()
+		var listResp *admin.Members
+		done := metrics.Track("groups", "list_members")
+		err := withRetry(func() (err error) {
+			listResp, err = c.adminSvc.Members.List(c.Group).MaxResults(200).PageToken(pageToken).Do()
+			return err
+		})
+		done(err)
 		if err != nil {
 			return nil, err
 		}
@@ -43,14 +116,44 @@ func (c *Client) ListMembers() ([]*admin.Member, error) {
 	return memberList, nil
 }
 
-func (c *Client) AddMember(email string) error {
+// AddMember inserts a member. An empty role defaults to MEMBER and an empty
+// delivery setting leaves the group default in place.
+func (c *Client) AddMember(email, role, delivery string) error {
 	member := admin.Member{
-		Email: email,
+		Email:            email,
+		Role:             role,
+		DeliverySettings: delivery,
 	}
-	_, err := c.adminSvc.Members.Insert(c.Group, &member).Do()
+	done := metrics.Track("groups", "add_member")
+	err := ignoreAlreadyMember(withRetry(func() error {
+		_, err := c.adminSvc.Members.Insert(c.Group, &member).Do()
+		return err
+	}))
+	done(err)
+	return err
+}
+
+// UpdateMember changes the role and/or delivery settings of an existing member.
+// Empty values are left unchanged.
+func (c *Client) UpdateMember(email, role, delivery string) error {
+	member := admin.Member{
+		Role:             role,
+		DeliverySettings: delivery,
+	}
+	done := metrics.Track("groups", "update_member")
+	err := withRetry(func() error {
+		_, err := c.adminSvc.Members.Patch(c.Group, email, &member).Do()
+		return err
+	})
+	done(err)
 	return err
 }
 
 func (c *Client) RemoveMember(email string) error {
-	return c.adminSvc.Members.Delete(c.Group, email).Do()
+	done := metrics.Track("groups", "remove_member")
+	err := ignoreNotFound(withRetry(func() error {
+		return c.adminSvc.Members.Delete(c.Group, email).Do()
+	}))
+	done(err)
+	return err
 }
@@ -0,0 +1,83 @@
+package groups
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+const maxAttempts = 6
+const initialBackoff = 500 * time.Millisecond
+const maxBackoff = 32 * time.Second
+
+// retryable reports whether the Admin SDK asked us to slow down or had a hiccup
+func retryable(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	if gerr.Code == http.StatusTooManyRequests || gerr.Code >= 500 {
+		return true
+	}
+	if gerr.Code == http.StatusForbidden {
+		for _, e := range gerr.Errors {
+			switch e.Reason {
+			case "rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasStatus(err error, code int) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == code
+}
+
+// adding someone who's already there leaves them where we want them
+func ignoreAlreadyMember(err error) error {
+	if hasStatus(err, http.StatusConflict) {
+		return nil
+	}
+	return err
+}
+
+// as does removing someone who's already gone
+func ignoreNotFound(err error) error {
+	if hasStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
+// backoff returns an exponential delay with full jitter for the given retry
+func backoff(retry int) time.Duration {
+	d := initialBackoff << retry
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func withRetry(fn func() error) error {
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff(attempt - 1))
+		}
+		err = fn()
+		if !retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// IsNotFound reports whether the member or group doesn't exist
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
@@ -0,0 +1,140 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	defaultCacheTTL = 30 * time.Second
+)
+
+// Check returns an error if a dependency isn't usable
+type Check func() error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker serves liveness and readiness probes
+type Checker struct {
+	// how long each readiness check gets
+	Timeout time.Duration
+	// readiness results are reused for this long so frequent probes don't
+	// turn into a stream of calls to SFDC, Discord and Google
+	CacheTTL time.Duration
+
+	checks   []namedCheck
+	draining int32
+
+	mu        sync.Mutex
+	last      status
+	checkedAt time.Time
+}
+
+type status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func NewChecker() *Checker {
+	return &Checker{Timeout: defaultTimeout, CacheTTL: defaultCacheTTL}
+}
+
+// Add registers a readiness check. Not safe to call once serving.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Drain fails readiness from now on so no new traffic is routed here during shutdown
+func (c *Checker) Drain() {
+	atomic.StoreInt32(&c.draining, 1)
+}
+
+// Healthz reports that the process is up, without checking anything else
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, status{Status: "ok"})
+}
+
+// Readyz reports the cached check results, running the checks again once
+// they're older than CacheTTL. Failures are logged but only reported as
+// failed, since the probe isn't authenticated.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&c.draining) == 1 {
+		writeStatus(w, http.StatusServiceUnavailable, status{Status: "draining"})
+		return
+	}
+
+	resp := c.results()
+	code := http.StatusOK
+	if resp.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeStatus(w, code, resp)
+}
+
+// results holds the lock while checking, so probes that arrive together
+// share one round of checks
+func (c *Checker) results() status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < c.CacheTTL {
+		return c.last
+	}
+	c.last = c.runChecks()
+	c.checkedAt = time.Now()
+	return c.last
+}
+
+// runChecks runs every check concurrently
+func (c *Checker) runChecks() status {
+	resp := status{Status: "ok", Checks: make(map[string]string, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			err := c.run(nc.check)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("Readiness check %s failed: %s", nc.name, err)
+				resp.Status = "unavailable"
+				resp.Checks[nc.name] = "failed"
+				return
+			}
+			resp.Checks[nc.name] = "ok"
+		}(nc)
+	}
+	wg.Wait()
+	return resp
+}
+
+// run gives up waiting after the timeout, the check itself keeps going
+func (c *Checker) run(check Check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(c.Timeout):
+		return fmt.Errorf("timed out after %s", c.Timeout)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, s status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,53 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadyzCachesResults(t *testing.T) {
+	var calls int32
+	c := NewChecker()
+	c.Add("dep", func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		c.Readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the check to run once, ran %d times", calls)
+	}
+
+	c.CacheTTL = 0
+	c.Readyz(httptest.NewRecorder(), httptest.NewRequest("GET", "/readyz", nil))
+	if calls != 2 {
+		t.Errorf("expected the check to run again without a cache, ran %d times", calls)
+	}
+}
+
+func TestReadyzFailureAndDrain(t *testing.T) {
+	c := NewChecker()
+	c.Add("dep", func() error { return errors.New("down") })
+
+	rec := httptest.NewRecorder()
+	c.Readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for a failed check, got %d", rec.Code)
+	}
+
+	c.Drain()
+	rec = httptest.NewRecorder()
+	c.Readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while draining, got %d", rec.Code)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/theforgeinitiative/integrations/metrics"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 	"gopkg.in/square/go-jose.v2/json"
@@ -78,7 +79,10 @@ func (c *Client) GenerateOTP(lock, name string) (string, error) {
 		AccessName: name,
 	}
 
-	return c.getToken(url, otpReq)
+	done := metrics.Track("igloohome", "otp")
+	tok, err := c.getToken(url, otpReq)
+	done(err)
+	return tok, err
 }
 
 func (c *Client) GenerateHourly(lock, name string, duration time.Duration) (string, time.Time, error) {
@@ -97,7 +101,9 @@ func (c *Client) GenerateHourly(lock, name string, duration time.Duration) (stri
 		AccessName: name,
 	}
 
+	done := metrics.Track("igloohome", "hourly")
 	tok, err := c.getToken(url, otpReq)
+	done(err)
 	return tok, endDate, err
 }
 
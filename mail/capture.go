@@ -0,0 +1,49 @@
+package mail
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// CaptureTransport keeps emails in memory instead of sending them, for local
+// development and tests
+type CaptureTransport struct {
+	mu     sync.Mutex
+	emails []Email
+}
+
+func NewCaptureTransport() *CaptureTransport {
+	return &CaptureTransport{}
+}
+
+func (t *CaptureTransport) Send(email Email) error {
+	t.mu.Lock()
+	t.emails = append(t.emails, email)
+	t.mu.Unlock()
+	log.Printf("Captured email to %s: %s", strings.Join(email.To, ", "), email.Subject)
+	return nil
+}
+
+// Emails returns everything sent so far, oldest first
+func (t *CaptureTransport) Emails() []Email {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Email(nil), t.emails...)
+}
+
+// Last returns the most recent email, if any
+func (t *CaptureTransport) Last() (Email, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.emails) == 0 {
+		return Email{}, false
+	}
+	return t.emails[len(t.emails)-1], true
+}
+
+func (t *CaptureTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.emails = nil
+}
@@ -1,20 +1,23 @@
 package mail
 
-import (
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
-)
+type Address struct {
+	Name  string
+	Email string
+}
 
 type Client struct {
-	SendgridClient *sendgrid.Client
-	From           *mail.Email
-	ReportTo       *mail.Email
+	Transport Transport
+	From      Address
+	ReportTo  string
+	// optional SendGrid dynamic template IDs keyed by template name. When set and
+	// using the SendGrid transport, SendGrid renders the message from the typed data.
+	TemplateIDs map[string]string
 }
 
-func NewClient(apiKey, senderName, senderEmail, reportEmail string) Client {
+func NewClient(transport Transport, senderName, senderEmail, reportEmail string) Client {
 	return Client{
-		SendgridClient: sendgrid.NewSendClient(apiKey),
-		From:           mail.NewEmail(senderName, senderEmail),
-		ReportTo:       mail.NewEmail("", reportEmail),
+		Transport: transport,
+		From:      Address{Name: senderName, Email: senderEmail},
+		ReportTo:  reportEmail,
 	}
 }
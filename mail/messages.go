@@ -0,0 +1,79 @@
+package mail
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/reconcile"
+)
+
+const (
+	StorageRequestTemplate   = "storage_request"
+	StorageApprovedTemplate  = "storage_approved"
+	VerificationCodeTemplate = "verification_code"
+	ExpiryReminderTemplate   = "expiry_reminder"
+	ReconcileReportTemplate  = "reconcile_report"
+)
+
+// Message is the data for one of the registered templates
+type Message interface {
+	TemplateName() string
+}
+
+// StorageRequest goes to the storage approvers when a member asks for access
+type StorageRequest struct {
+	FirstName    string `json:"firstName"`
+	LastName     string `json:"lastName"`
+	Email        string `json:"email"`
+	ApprovalLink string `json:"approvalLink"`
+}
+
+func (StorageRequest) TemplateName() string { return StorageRequestTemplate }
+
+// StorageApproved tells a member they can start using storage
+type StorageApproved struct {
+	FirstName    string `json:"firstName"`
+	Instructions string `json:"instructions"`
+}
+
+func (StorageApproved) TemplateName() string { return StorageApprovedTemplate }
+
+// VerificationCode confirms a member owns an address
+type VerificationCode struct {
+	FirstName string        `json:"firstName"`
+	Code      string        `json:"code"`
+	ValidFor  time.Duration `json:"validFor"`
+}
+
+func (VerificationCode) TemplateName() string { return VerificationCodeTemplate }
+
+func (v VerificationCode) Minutes() int {
+	return int(v.ValidFor.Minutes())
+}
+
+// ExpiryReminder warns a member before their membership lapses
+type ExpiryReminder struct {
+	FirstName string    `json:"firstName"`
+	EndDate   time.Time `json:"endDate"`
+	RenewLink string    `json:"renewLink"`
+}
+
+func (ExpiryReminder) TemplateName() string { return ExpiryReminderTemplate }
+
+// ReconcileReport wraps the report renderings from the reconcile package
+type ReconcileReport struct {
+	Report reconcile.Report `json:"report"`
+}
+
+func (ReconcileReport) TemplateName() string { return ReconcileReportTemplate }
+
+func (r ReconcileReport) Text() (string, error) {
+	b, err := r.Report.RenderText()
+	return string(b), err
+}
+
+func (r ReconcileReport) HTML() (htmltemplate.HTML, error) {
+	b, err := r.Report.RenderHTML()
+	return htmltemplate.HTML(bytes.TrimSpace(b)), err
+}
@@ -0,0 +1,64 @@
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/theforgeinitiative/integrations/metrics"
+)
+
+type Recipients struct {
+	To      []string
+	CC      []string
+	BCC     []string
+	ReplyTo string
+}
+
+// Send renders msg with its registered template and sends it to r
+func (c *Client) Send(r Recipients, msg Message) error {
+	if len(r.To) == 0 {
+		return errors.New("no recipients")
+	}
+
+	rendered, err := Render(msg)
+	if err != nil {
+		return err
+	}
+	email := Email{
+		From:    c.From,
+		To:      r.To,
+		CC:      r.CC,
+		BCC:     r.BCC,
+		ReplyTo: r.ReplyTo,
+		Subject: rendered.Subject,
+		Text:    rendered.Text,
+		HTML:    rendered.HTML,
+	}
+	if id, ok := c.TemplateIDs[msg.TemplateName()]; ok && len(id) > 0 {
+		email.TemplateID = id
+		email.TemplateData, err = templateData(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	done := metrics.Track("mail", msg.TemplateName())
+	err = c.Transport.Send(email)
+	done(err)
+	return err
+}
+
+// templateData flattens msg through its json tags for SendGrid dynamic templates
+func templateData(msg Message) (map[string]interface{}, error) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s template data: %w", msg.TemplateName(), err)
+	}
+	var data map[string]interface{}
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s template data: %w", msg.TemplateName(), err)
+	}
+	return data, nil
+}
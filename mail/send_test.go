@@ -0,0 +1,85 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/reconcile"
+)
+
+func TestSendCapture(t *testing.T) {
+	transport := NewCaptureTransport()
+	c := NewClient(transport, "TFI Bot", "bot@example.org", "board@example.org")
+
+	err := c.Send(Recipients{
+		To:      []string{"ada@example.com"},
+		CC:      []string{"storage@example.org"},
+		ReplyTo: "storage@example.org",
+	}, VerificationCode{FirstName: "Ada", Code: "123456", ValidFor: 15 * time.Minute})
+	if err != nil {
+		t.Fatalf("Send: %s", err)
+	}
+
+	email, ok := transport.Last()
+	if !ok {
+		t.Fatal("nothing was captured")
+	}
+	if email.From != (Address{Name: "TFI Bot", Email: "bot@example.org"}) {
+		t.Errorf("from = %+v", email.From)
+	}
+	if len(email.To) != 1 || email.To[0] != "ada@example.com" {
+		t.Errorf("to = %v", email.To)
+	}
+	if len(email.CC) != 1 || email.CC[0] != "storage@example.org" || email.ReplyTo != "storage@example.org" {
+		t.Errorf("cc = %v, reply to = %q", email.CC, email.ReplyTo)
+	}
+	if email.Subject != "Confirm your TFI Google Group address" {
+		t.Errorf("subject = %q", email.Subject)
+	}
+	for _, body := range []string{email.Text, email.HTML} {
+		if !strings.Contains(body, "123456") || !strings.Contains(body, "Ada") {
+			t.Errorf("body missing code or name:\n%s", body)
+		}
+	}
+	if len(email.TemplateID) > 0 {
+		t.Errorf("template id = %q, want none without configured IDs", email.TemplateID)
+	}
+}
+
+func TestSendReconcileReport(t *testing.T) {
+	transport := NewCaptureTransport()
+	c := NewClient(transport, "TFI Bot", "bot@example.org", "board@example.org")
+
+	err := c.SendReconcileReport(reconcile.Report{
+		Date:   time.Date(2024, time.March, 5, 14, 30, 0, 0, time.UTC),
+		Groups: map[string]reconcile.Changes{"members": {Deletions: []string{"lapsed@example.com"}}},
+	})
+	if err != nil {
+		t.Fatalf("SendReconcileReport: %s", err)
+	}
+
+	emails := transport.Emails()
+	if len(emails) != 1 {
+		t.Fatalf("captured %d emails, want 1", len(emails))
+	}
+	if len(emails[0].To) != 1 || emails[0].To[0] != "board@example.org" {
+		t.Errorf("to = %v, want the report address", emails[0].To)
+	}
+	if !strings.Contains(emails[0].Text, "lapsed@example.com") || !strings.Contains(emails[0].HTML, "lapsed@example.com") {
+		t.Error("report body is missing the deletion")
+	}
+}
+
+func TestSendNoRecipients(t *testing.T) {
+	transport := NewCaptureTransport()
+	c := NewClient(transport, "TFI Bot", "bot@example.org", "")
+
+	err := c.Send(Recipients{}, StorageApproved{FirstName: "Ada"})
+	if err == nil {
+		t.Error("expected an error without recipients")
+	}
+	if len(transport.Emails()) != 0 {
+		t.Error("email captured without recipients")
+	}
+}
@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"fmt"
+
+	"github.com/sendgrid/sendgrid-go"
+	sgmail "github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+type SendgridTransport struct {
+	client *sendgrid.Client
+}
+
+func NewSendgridTransport(apiKey string) *SendgridTransport {
+	return &SendgridTransport{client: sendgrid.NewSendClient(apiKey)}
+}
+
+func (t *SendgridTransport) Send(email Email) error {
+	m := sgmail.NewV3Mail()
+	m.SetFrom(sgmail.NewEmail(email.From.Name, email.From.Email))
+	if len(email.ReplyTo) > 0 {
+		m.SetReplyTo(sgmail.NewEmail("", email.ReplyTo))
+	}
+	p := sgmail.NewPersonalization()
+	p.AddTos(sendgridAddresses(email.To)...)
+	p.AddCCs(sendgridAddresses(email.CC)...)
+	p.AddBCCs(sendgridAddresses(email.BCC)...)
+	m.AddPersonalizations(p)
+
+	if len(email.TemplateID) > 0 {
+		for k, v := range email.TemplateData {
+			p.SetDynamicTemplateData(k, v)
+		}
+		m.SetTemplateID(email.TemplateID)
+	} else {
+		m.Subject = email.Subject
+		m.AddContent(sgmail.NewContent("text/plain", email.Text))
+		if len(email.HTML) > 0 {
+			m.AddContent(sgmail.NewContent("text/html", email.HTML))
+		}
+	}
+
+	resp, err := t.client.Send(m)
+	if err != nil {
+		return fmt.Errorf("failed to send email via sendgrid: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("received bad status from sendgrid: %d: %s", resp.StatusCode, resp.Body)
+	}
+	return nil
+}
+
+func sendgridAddresses(emails []string) []*sgmail.Email {
+	addrs := make([]*sgmail.Email, len(emails))
+	for i, e := range emails {
+		addrs[i] = sgmail.NewEmail("", e)
+	}
+	return addrs
+}
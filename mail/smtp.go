@@ -0,0 +1,133 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSMTPPort = 587
+
+// SMTPTransport sends through any SMTP relay, e.g. a local Mailpit for development.
+// SendGrid template IDs are ignored, the rendered bodies are always sent.
+type SMTPTransport struct {
+	addr string
+	host string
+	auth smtp.Auth
+}
+
+func NewSMTPTransport(cfg SMTPConfig) (*SMTPTransport, error) {
+	if len(cfg.Host) == 0 {
+		return nil, errors.New("smtp host is required")
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+	t := &SMTPTransport{
+		addr: cfg.Host + ":" + strconv.Itoa(port),
+		host: cfg.Host,
+	}
+	// net/smtp only sends credentials over TLS or to localhost
+	if len(cfg.Username) > 0 {
+		t.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return t, nil
+}
+
+func (t *SMTPTransport) Send(email Email) error {
+	msg, err := buildMessage(email)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+	recipients := append(append(append([]string{}, email.To...), email.CC...), email.BCC...)
+	err = smtp.SendMail(t.addr, t.auth, email.From.Email, recipients, msg)
+	if err != nil {
+		return fmt.Errorf("failed to send email via smtp: %w", err)
+	}
+	return nil
+}
+
+// buildMessage writes an RFC 5322 message, multipart/alternative when there's
+// an HTML body. BCC recipients are left out of the headers.
+func buildMessage(email Email) ([]byte, error) {
+	// addresses can come from members, so a line break could inject headers
+	for _, h := range []struct {
+		name   string
+		values []string
+	}{
+		{"From", []string{email.From.Email}},
+		{"To", email.To},
+		{"Cc", email.CC},
+		{"Reply-To", []string{email.ReplyTo}},
+	} {
+		for _, v := range h.values {
+			if strings.ContainsAny(v, "\r\n") {
+				return nil, fmt.Errorf("invalid %s address %q: contains a line break", h.name, v)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	from := netmail.Address{Name: email.From.Name, Address: email.From.Email}
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(email.To, ", "))
+	if len(email.CC) > 0 {
+		fmt.Fprintf(&buf, "Cc: %s\r\n", strings.Join(email.CC, ", "))
+	}
+	if len(email.ReplyTo) > 0 {
+		fmt.Fprintf(&buf, "Reply-To: %s\r\n", email.ReplyTo)
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprint(&buf, "MIME-Version: 1.0\r\n")
+
+	if len(email.HTML) == 0 {
+		fmt.Fprint(&buf, "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		err := writeQuotedPrintable(&buf, email.Text)
+		return buf.Bytes(), err
+	}
+
+	w := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = writeQuotedPrintable(pw, part.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := w.Close()
+	return buf.Bytes(), err
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	_, err := qp.Write([]byte(s))
+	if err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,83 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	netmail "net/mail"
+	"strings"
+	"testing"
+)
+
+func testEmail() Email {
+	return Email{
+		From:    Address{Name: "TFI", Email: "noreply@example.org"},
+		To:      []string{"jane@example.org"},
+		CC:      []string{"board@example.org"},
+		BCC:     []string{"archive@example.org"},
+		ReplyTo: "board@example.org",
+		Subject: "Your code",
+		Text:    "Your code is 123456",
+	}
+}
+
+func TestBuildMessage(t *testing.T) {
+	b, err := buildMessage(testEmail())
+	if err != nil {
+		t.Fatalf("buildMessage: %s", err)
+	}
+	msg, err := netmail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("invalid message: %s\n%s", err, b)
+	}
+	for header, want := range map[string]string{
+		"From":     `"TFI" <noreply@example.org>`,
+		"To":       "jane@example.org",
+		"Cc":       "board@example.org",
+		"Reply-To": "board@example.org",
+		"Bcc":      "",
+	} {
+		if got := msg.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	body, _ := io.ReadAll(msg.Body)
+	if !strings.Contains(string(body), "123456") {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestBuildMessageRejectsLineBreaks(t *testing.T) {
+	tests := map[string]func(*Email){
+		"to":       func(e *Email) { e.To = []string{"jane@example.org\r\nBcc: victim@example.com"} },
+		"cc":       func(e *Email) { e.CC = []string{"board@example.org\nBcc: victim@example.com"} },
+		"reply-to": func(e *Email) { e.ReplyTo = "board@example.org\rBcc: victim@example.com" },
+		"from":     func(e *Email) { e.From.Email = "noreply@example.org\r\nX-Injected: 1" },
+	}
+	for name, inject := range tests {
+		t.Run(name, func(t *testing.T) {
+			email := testEmail()
+			inject(&email)
+			_, err := buildMessage(email)
+			if err == nil {
+				t.Error("expected an error for a line break in a header")
+			}
+		})
+	}
+}
+
+func TestBuildMessageEncodesNameAndSubject(t *testing.T) {
+	email := testEmail()
+	email.From.Name = "TFI\r\nX-Injected: 1"
+	email.Subject = "Hi\r\nX-Injected: 1"
+	b, err := buildMessage(email)
+	if err != nil {
+		t.Fatalf("buildMessage: %s", err)
+	}
+	msg, err := netmail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("invalid message: %s\n%s", err, b)
+	}
+	if got := msg.Header.Get("X-Injected"); len(got) > 0 {
+		t.Errorf("header was injected:\n%s", b)
+	}
+}
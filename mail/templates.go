@@ -0,0 +1,72 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+type emailTemplate struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+// subjects are templates too, executed with the same data as the body
+var subjects = map[string]string{
+	StorageRequestTemplate:   "Storage Unit Access Request",
+	StorageApprovedTemplate:  "Your TFI storage access is approved",
+	VerificationCodeTemplate: "Confirm your TFI Google Group address",
+	ExpiryReminderTemplate:   `Your TFI membership ends {{ .EndDate.Format "Jan 2" }}`,
+	ReconcileReportTemplate:  "TFI Integrations Reconciliation Report",
+}
+
+var templates = mustParseTemplates()
+
+func mustParseTemplates() map[string]emailTemplate {
+	parsed := make(map[string]emailTemplate, len(subjects))
+	for name, subject := range subjects {
+		parsed[name] = emailTemplate{
+			subject: template.Must(template.New(name + ".subject").Parse(subject)),
+			text:    template.Must(template.ParseFS(templateFS, "templates/"+name+".txt")),
+			html:    htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/"+name+".html")),
+		}
+	}
+	return parsed
+}
+
+// Rendered is a message ready to hand off for delivery
+type Rendered struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Render executes the subject, text and HTML templates registered for msg
+func Render(msg Message) (Rendered, error) {
+	t, ok := templates[msg.TemplateName()]
+	if !ok {
+		return Rendered{}, fmt.Errorf("no email template named %s", msg.TemplateName())
+	}
+
+	var subject, text, html bytes.Buffer
+	err := t.subject.Execute(&subject, msg)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("failed to render %s subject: %w", msg.TemplateName(), err)
+	}
+	err = t.text.Execute(&text, msg)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("failed to render %s text: %w", msg.TemplateName(), err)
+	}
+	err = t.html.Execute(&html, msg)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("failed to render %s html: %w", msg.TemplateName(), err)
+	}
+
+	return Rendered{Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
@@ -0,0 +1,105 @@
+package mail
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/reconcile"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+// one message per template, with every field set
+var goldenMessages = []Message{
+	StorageRequest{
+		FirstName:    "Ada",
+		LastName:     "Lovelace",
+		Email:        "ada@example.com",
+		ApprovalLink: "https://example.com/approve?id=003xx&token=abc",
+	},
+	StorageApproved{
+		FirstName:    "Ada",
+		Instructions: "Your unit is <b>#12</b> & the code is on the door.",
+	},
+	VerificationCode{
+		FirstName: "Ada",
+		Code:      "123456",
+		ValidFor:  15 * time.Minute,
+	},
+	ExpiryReminder{
+		FirstName: "Ada",
+		EndDate:   time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC),
+		RenewLink: "https://example.com/renew",
+	},
+	ReconcileReport{Report: reconcile.Report{
+		Date:     time.Date(2024, time.March, 5, 14, 30, 0, 0, time.UTC),
+		Duration: 2500 * time.Millisecond,
+		User:     "scheduler@example.com",
+		CheckMeIn: reconcile.Changes{
+			Additions: []string{"Ada L (100)"},
+			Deletions: []string{"Charles B (200)"},
+			Errored:   []string{"Grace H (300)"},
+		},
+		Groups: map[string]reconcile.Changes{
+			"members": {
+				Additions: []string{"ada@example.com"},
+				Updates:   []string{"board@example.com (MANAGER)"},
+				Deletions: []string{"lapsed@example.com"},
+			},
+		},
+		Discord: map[string]reconcile.Changes{
+			"tfi": {
+				Additions: []string{"ada#0001"},
+				Deletions: []string{},
+			},
+		},
+	}},
+}
+
+func TestTemplatesGolden(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, msg := range goldenMessages {
+		name := msg.TemplateName()
+		covered[name] = true
+		t.Run(name, func(t *testing.T) {
+			rendered, err := Render(msg)
+			if err != nil {
+				t.Fatalf("Render: %s", err)
+			}
+			checkGolden(t, name+".subject", rendered.Subject)
+			checkGolden(t, name+".txt", rendered.Text)
+			checkGolden(t, name+".html", rendered.HTML)
+		})
+	}
+	for name := range subjects {
+		if !covered[name] {
+			t.Errorf("no golden test for template %s", name)
+		}
+	}
+}
+
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		err := os.MkdirAll("testdata", 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(got), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run with -update to create it: %s", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s doesn't match %s\ngot:\n%s\nwant:\n%s", name, path, got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package mail
+
+import (
+	"fmt"
+)
+
+const (
+	TransportSendgrid = "sendgrid"
+	TransportSMTP     = "smtp"
+	TransportCapture  = "capture"
+)
+
+// Email is a rendered message ready for a transport
+type Email struct {
+	From    Address
+	To      []string
+	CC      []string
+	BCC     []string
+	ReplyTo string
+	Subject string
+	Text    string
+	HTML    string
+	// only used by transports that render templates themselves
+	TemplateID   string
+	TemplateData map[string]interface{}
+}
+
+type Transport interface {
+	Send(email Email) error
+}
+
+type TransportConfig struct {
+	Type   string     `mapstructure:"transport"`
+	APIKey string     `mapstructure:"apiKey"`
+	SMTP   SMTPConfig `mapstructure:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// NewTransport picks a transport from config, defaulting to SendGrid
+func NewTransport(cfg TransportConfig) (Transport, error) {
+	switch cfg.Type {
+	case "", TransportSendgrid:
+		return NewSendgridTransport(cfg.APIKey), nil
+	case TransportSMTP:
+		return NewSMTPTransport(cfg.SMTP)
+	case TransportCapture:
+		return NewCaptureTransport(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.Type)
+	}
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tfi"
+
+var (
+	clientCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_calls_total",
+		Help:      "Calls to external services by client, operation and result.",
+	}, []string{"client", "operation", "result"})
+
+	clientDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "client_call_duration_seconds",
+		Help:      "Latency of calls to external services, including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"client", "operation"})
+
+	// LinksCompleted counts Discord users linked to a membership
+	LinksCompleted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "membership_links_total",
+		Help:      "Discord users linked to a membership.",
+	})
+
+	// UnlockCodes counts storage unlock codes issued, by lock
+	UnlockCodes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unlock_codes_issued_total",
+		Help:      "Storage unlock codes issued.",
+	}, []string{"lock"})
+
+	// DoorbellRings counts /letmein uses, by door
+	DoorbellRings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "doorbell_rings_total",
+		Help:      "Doorbell rings from Discord.",
+	}, []string{"door"})
+
+	// ReconcileChanges counts what reconcile did, by target and action. Failed
+	// changes use the action "failed".
+	ReconcileChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_changes_total",
+		Help:      "Changes applied by reconcile.",
+	}, []string{"target", "action"})
+
+	// ReconcileDuration times applied runs. kind is full or contacts.
+	ReconcileDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "How long applied reconcile runs take.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"kind"})
+)
+
+// Track times one call to an external service. Call the returned func with
+// the call's error once it's done:
+//
+//	done := metrics.Track("sfdc", "query")
+//	result, err := c.SFClient.Query(q)
+//	done(err)
+func Track(client, operation string) func(error) {
+	start := time.Now()
+	return func(err error) {
+		clientDuration.WithLabelValues(client, operation).Observe(time.Since(start).Seconds())
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		clientCalls.WithLabelValues(client, operation, result).Inc()
+	}
+}
+
+// Handler serves everything registered with the default registry, which
+// includes Go runtime and process metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
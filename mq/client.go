@@ -1,35 +1,195 @@
 package mq
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/theforgeinitiative/integrations/metrics"
 )
 
 const doorTopicPrefix = "door/"
-const clientID = "forgebot"
+const defaultClientID = "forgebot"
+
+const connectTimeout = 10 * time.Second
+const publishTimeout = 5 * time.Second
+
+var ErrNotConnected = errors.New("not connected to mqtt broker")
+
+type Config struct {
+	Broker   string `mapstructure:"broker"`
+	ClientID string `mapstructure:"clientId"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// PEM bundle used to verify the broker's certificate
+	CAFile string `mapstructure:"caFile"`
+	// will message published by the broker if we drop off unexpectedly.
+	// "online" is published retained to the same topic on every connect.
+	StatusTopic string `mapstructure:"statusTopic"`
+
+	Events EventsConfig `mapstructure:"events"`
+
+	OnConnect    func()      `mapstructure:"-"`
+	OnDisconnect func(error) `mapstructure:"-"`
+}
+
+// Status times are nil until the client first connects or disconnects
+type Status struct {
+	Connected      bool       `json:"connected"`
+	LastConnect    *time.Time `json:"lastConnect,omitempty"`
+	LastDisconnect *time.Time `json:"lastDisconnect,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+}
 
 type Client struct {
+	Doors     []Door
+	UnlockKey []byte
+	UnlockTTL time.Duration
+	// time zone for door hours
+	Location *time.Location
+
 	mqttClient mqtt.Client
+	events     EventsConfig
+	statusMu   sync.RWMutex
+	status     Status
 }
 
-func NewClient(broker string) (Client, error) {
+func NewClient(cfg Config) (*Client, error) {
+	c := &Client{events: cfg.Events}
+
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(broker)
-	opts.SetClientID(clientID)
+	opts.AddBroker(cfg.Broker)
+	opts.SetClientID(clientID(cfg.ClientID))
+	opts.SetUsername(cfg.Username)
+	opts.SetPassword(cfg.Password)
 	opts.SetKeepAlive(60 * time.Second)
 	opts.SetPingTimeout(1 * time.Second)
-	opts.SetConnectTimeout(10 * time.Second)
+	opts.SetConnectTimeout(connectTimeout)
 	opts.SetConnectRetry(true)
+	opts.SetAutoReconnect(true)
+
+	if len(cfg.CAFile) > 0 {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mqtt ca bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		opts.SetTLSConfig(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	}
+
+	if len(cfg.StatusTopic) > 0 {
+		opts.SetWill(cfg.StatusTopic, "offline", 1, true)
+	}
+
+	opts.SetOnConnectHandler(func(mc mqtt.Client) {
+		now := time.Now()
+		c.statusMu.Lock()
+		c.status.LastConnect = &now
+		c.status.LastError = ""
+		c.statusMu.Unlock()
+
+		if len(cfg.StatusTopic) > 0 {
+			mc.Publish(cfg.StatusTopic, 1, true, "online")
+		}
+		if cfg.OnConnect != nil {
+			cfg.OnConnect()
+		}
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		now := time.Now()
+		c.statusMu.Lock()
+		c.status.LastDisconnect = &now
+		if err != nil {
+			c.status.LastError = err.Error()
+		}
+		c.statusMu.Unlock()
+
+		if cfg.OnDisconnect != nil {
+			cfg.OnDisconnect(err)
+		}
+	})
 
-	c := mqtt.NewClient(opts)
-	c.Connect()
+	c.mqttClient = mqtt.NewClient(opts)
+	token := c.mqttClient.Connect()
+	// with connect retry enabled the token only completes once we're connected,
+	// so don't hold up startup. the client keeps retrying in the background.
+	if !token.WaitTimeout(connectTimeout) {
+		return c, fmt.Errorf("timed out connecting to %s, will keep retrying", cfg.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return c, fmt.Errorf("failed to connect to %s: %w", cfg.Broker, err)
+	}
+
+	return c, nil
+}
+
+// clientID returns the configured ID, or one unique to this host so that
+// multiple instances don't kick each other off the broker
+func clientID(configured string) string {
+	if len(configured) > 0 {
+		return configured
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return fmt.Sprintf("%s-%d", defaultClientID, os.Getpid())
+	}
+	return fmt.Sprintf("%s-%s-%d", defaultClientID, host, os.Getpid())
+}
+
+// Connected asks paho directly since the connect handler runs asynchronously
+// and may not have updated our status yet
+func (c *Client) Connected() bool {
+	return c.mqttClient.IsConnectionOpen()
+}
+
+// Ping fails unless the broker connection is up
+func (c *Client) Ping() error {
+	if !c.Connected() {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+func (c *Client) Status() Status {
+	c.statusMu.RLock()
+	status := c.status
+	c.statusMu.RUnlock()
+	status.Connected = c.Connected()
+	return status
+}
+
+func (c *Client) Close() {
+	c.mqttClient.Disconnect(250)
+}
+
+func (c *Client) RingDoorbell(door string) error {
+	return c.publish(doorTopicPrefix+door, 0, false, "ring")
+}
 
-	return Client{c}, nil
+// publish fails fast when disconnected rather than queueing the message,
+// since a doorbell ring delivered minutes later isn't helpful to anybody
+func (c *Client) publish(topic string, qos byte, retained bool, payload interface{}) error {
+	done := metrics.Track("mqtt", "publish")
+	err := c.waitPublish(topic, qos, retained, payload)
+	done(err)
+	return err
 }
 
-func (c Client) RingDoorbell(door string) error {
-	token := c.mqttClient.Publish(doorTopicPrefix+door, 0, false, "ring")
-	token.Wait()
+func (c *Client) waitPublish(topic string, qos byte, retained bool, payload interface{}) error {
+	if !c.Connected() {
+		return ErrNotConnected
+	}
+	token := c.mqttClient.Publish(topic, qos, retained, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
 	return token.Error()
 }
@@ -0,0 +1,104 @@
+package mq
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/mq/mqtest"
+)
+
+func newTestClient(t *testing.T) (*Client, *mqtest.Broker) {
+	t.Helper()
+	broker, err := mqtest.NewBroker()
+	if err != nil {
+		t.Fatalf("failed to start broker: %s", err)
+	}
+	t.Cleanup(func() { broker.Close() })
+
+	c, err := NewClient(Config{Broker: broker.URL, ClientID: "mq-test"})
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	t.Cleanup(c.Close)
+	return c, broker
+}
+
+func TestRingDoorbell(t *testing.T) {
+	c, broker := newTestClient(t)
+
+	err := c.RingDoorbell("loft")
+	if err != nil {
+		t.Fatalf("RingDoorbell: %s", err)
+	}
+
+	msg, err := broker.WaitForMessage("door/loft", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Payload) != "ring" {
+		t.Errorf("payload = %q, want %q", msg.Payload, "ring")
+	}
+	if msg.ClientID != "mq-test" {
+		t.Errorf("client id = %q, want %q", msg.ClientID, "mq-test")
+	}
+	if msg.Retain {
+		t.Error("doorbell ring should not be retained")
+	}
+}
+
+func TestRingDoorbellDisconnected(t *testing.T) {
+	c, broker := newTestClient(t)
+	broker.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("client still connected after broker closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err := c.RingDoorbell("loft")
+	if !errors.Is(err, ErrNotConnected) {
+		t.Errorf("RingDoorbell err = %v, want %v", err, ErrNotConnected)
+	}
+	err = c.Ping()
+	if !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Ping err = %v, want %v", err, ErrNotConnected)
+	}
+	status := c.Status()
+	if status.Connected || status.LastConnect == nil || status.LastDisconnect == nil || status.LastDisconnect.Before(*status.LastConnect) {
+		t.Errorf("status = %+v, want disconnected after connecting", status)
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	c, _ := newTestClient(t)
+	if err := c.Ping(); err != nil {
+		t.Fatalf("Ping: %s", err)
+	}
+	// the connect handler runs after the client reports it's connected
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Status().LastConnect == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("connect time never recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b, err := json.Marshal(c.Status())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	json.Unmarshal(b, &got)
+	if got["connected"] != true || got["lastConnect"] == nil {
+		t.Errorf("status = %s, want connected with a connect time", b)
+	}
+	// never disconnected, so there's no zero time
+	if _, ok := got["lastDisconnect"]; ok {
+		t.Errorf("status = %s, want no lastDisconnect", b)
+	}
+}
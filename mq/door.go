@@ -0,0 +1,111 @@
+package mq
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const unlockTopicSuffix = "/unlock"
+
+// default lifetime of an unlock command before controllers should reject it
+const defaultUnlockTTL = 30 * time.Second
+
+type Door struct {
+	ID    string `mapstructure:"id"`
+	Label string `mapstructure:"label"`
+	// hours of the day (0-23) in Location when remote unlock is allowed
+	// leaving both at 0 allows unlocking at any time
+	OpenHour  int `mapstructure:"openHour"`
+	CloseHour int `mapstructure:"closeHour"`
+	// defaults to the server's local time, which is UTC on Cloud Run
+	Location *time.Location `mapstructure:"-"`
+}
+
+// Allowed reports whether the door may be unlocked remotely at time t
+func (d Door) Allowed(t time.Time) bool {
+	if d.OpenHour == d.CloseHour {
+		return true
+	}
+	if d.Location != nil {
+		t = t.In(d.Location)
+	}
+	h := t.Hour()
+	// window wraps past midnight
+	if d.OpenHour > d.CloseHour {
+		return h >= d.OpenHour || h < d.CloseHour
+	}
+	return h >= d.OpenHour && h < d.CloseHour
+}
+
+// UnlockCommand is the JSON payload published to door/{id}/unlock.
+// Controllers must check the signature and expiry, and must reject a nonce
+// they've already seen until its command expires, otherwise a captured
+// command can be replayed.
+type UnlockCommand struct {
+	Door      string `json:"door"`
+	Nonce     string `json:"nonce"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Signature string `json:"sig"`
+}
+
+// signingPayload is what controllers must reproduce to verify the HMAC
+func (u UnlockCommand) signingPayload() string {
+	return fmt.Sprintf("%s|%s|%d|%d", u.Door, u.Nonce, u.IssuedAt, u.ExpiresAt)
+}
+
+func (c *Client) Door(id string) (Door, bool) {
+	for _, d := range c.Doors {
+		if d.ID == id {
+			d.Location = c.Location
+			return d, true
+		}
+	}
+	return Door{}, false
+}
+
+// UnlockEnabled reports whether there's a key to sign unlock commands with
+func (c *Client) UnlockEnabled() bool {
+	return len(c.UnlockKey) > 0
+}
+
+func (c *Client) UnlockDoor(door string) error {
+	if !c.UnlockEnabled() {
+		return errors.New("no unlock signing key configured")
+	}
+	ttl := c.UnlockTTL
+	if ttl == 0 {
+		ttl = defaultUnlockTTL
+	}
+
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	now := time.Now()
+	cmd := UnlockCommand{
+		Door:      door,
+		Nonce:     hex.EncodeToString(nonce),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	mac := hmac.New(sha256.New, c.UnlockKey)
+	mac.Write([]byte(cmd.signingPayload()))
+	cmd.Signature = hex.EncodeToString(mac.Sum(nil))
+
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to marshal unlock command: %w", err)
+	}
+
+	// QoS 1 since a dropped unlock leaves someone standing outside
+	return c.publish(doorTopicPrefix+door+unlockTopicSuffix, 1, false, payload)
+}
@@ -0,0 +1,178 @@
+package mq
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/mq/mqtest"
+)
+
+var testUnlockKey = []byte("unlock-key")
+
+func waitForUnlock(t *testing.T, broker *mqtest.Broker, door string) (UnlockCommand, mqtest.Message) {
+	t.Helper()
+	msg, err := broker.WaitForMessage("door/"+door+"/unlock", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmd UnlockCommand
+	err = json.Unmarshal(msg.Payload, &cmd)
+	if err != nil {
+		t.Fatalf("invalid unlock payload %q: %s", msg.Payload, err)
+	}
+	return cmd, msg
+}
+
+// verify checks a command the way a door controller would
+func verify(key []byte, cmd UnlockCommand) bool {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(fmt.Sprintf("%s|%s|%d|%d", cmd.Door, cmd.Nonce, cmd.IssuedAt, cmd.ExpiresAt)))
+	sig, err := hex.DecodeString(cmd.Signature)
+	return err == nil && hmac.Equal(sig, mac.Sum(nil))
+}
+
+func TestUnlockDoor(t *testing.T) {
+	c, broker := newTestClient(t)
+	c.UnlockKey = testUnlockKey
+	c.UnlockTTL = time.Minute
+
+	before := time.Now().Unix()
+	err := c.UnlockDoor("front")
+	if err != nil {
+		t.Fatalf("UnlockDoor: %s", err)
+	}
+	cmd, msg := waitForUnlock(t, broker, "front")
+
+	if msg.QoS != 1 || msg.Retain {
+		t.Errorf("published with qos %d retain %t, want qos 1 and not retained", msg.QoS, msg.Retain)
+	}
+	if cmd.Door != "front" || len(cmd.Nonce) != 32 {
+		t.Errorf("command = %+v", cmd)
+	}
+	if cmd.IssuedAt < before || cmd.IssuedAt > time.Now().Unix() {
+		t.Errorf("iat = %d, want about now", cmd.IssuedAt)
+	}
+	if cmd.ExpiresAt-cmd.IssuedAt != 60 {
+		t.Errorf("command lasts %ds, want the 60s ttl", cmd.ExpiresAt-cmd.IssuedAt)
+	}
+	if !verify(testUnlockKey, cmd) {
+		t.Error("signature doesn't verify with the unlock key")
+	}
+	if verify([]byte("other-key"), cmd) {
+		t.Error("signature verifies with the wrong key")
+	}
+
+	// any change to the signed fields breaks the signature
+	tampered := []func(*UnlockCommand){
+		func(u *UnlockCommand) { u.Door = "back" },
+		func(u *UnlockCommand) { u.Nonce = "00000000000000000000000000000000" },
+		func(u *UnlockCommand) { u.IssuedAt++ },
+		func(u *UnlockCommand) { u.ExpiresAt += 3600 },
+	}
+	for i, tamper := range tampered {
+		u := cmd
+		tamper(&u)
+		if verify(testUnlockKey, u) {
+			t.Errorf("tampered command %d still verifies", i)
+		}
+	}
+}
+
+func TestUnlockDoorDefaultTTLAndFreshNonce(t *testing.T) {
+	c, broker := newTestClient(t)
+	c.UnlockKey = testUnlockKey
+
+	err := c.UnlockDoor("front")
+	if err != nil {
+		t.Fatalf("UnlockDoor: %s", err)
+	}
+	first, _ := waitForUnlock(t, broker, "front")
+	if time.Duration(first.ExpiresAt-first.IssuedAt)*time.Second != defaultUnlockTTL {
+		t.Errorf("command lasts %ds, want the default %s", first.ExpiresAt-first.IssuedAt, defaultUnlockTTL)
+	}
+
+	err = c.UnlockDoor("front")
+	if err != nil {
+		t.Fatalf("UnlockDoor: %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(broker.MessagesOn("door/front/unlock")) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("second unlock command wasn't published")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var second UnlockCommand
+	json.Unmarshal(broker.MessagesOn("door/front/unlock")[1].Payload, &second)
+	if second.Nonce == first.Nonce {
+		t.Errorf("nonce %s was reused", first.Nonce)
+	}
+}
+
+func TestUnlockDoorWithoutKey(t *testing.T) {
+	c, _ := newTestClient(t)
+	if c.UnlockEnabled() {
+		t.Error("unlock enabled without a key")
+	}
+	err := c.UnlockDoor("front")
+	if err == nil {
+		t.Error("expected an error without an unlock key")
+	}
+}
+
+func TestDoorAllowed(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tzdata: %s", err)
+	}
+	tests := []struct {
+		name    string
+		door    Door
+		at      time.Time
+		allowed bool
+	}{
+		{"no window", Door{}, time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC), true},
+		{"inside day window", Door{OpenHour: 7, CloseHour: 23}, time.Date(2024, 3, 1, 7, 0, 0, 0, time.UTC), true},
+		{"close hour is excluded", Door{OpenHour: 7, CloseHour: 23}, time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC), false},
+		{"before day window", Door{OpenHour: 7, CloseHour: 23}, time.Date(2024, 3, 1, 6, 59, 0, 0, time.UTC), false},
+		{"wrapping window, evening", Door{OpenHour: 18, CloseHour: 2}, time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC), true},
+		{"wrapping window, after midnight", Door{OpenHour: 18, CloseHour: 2}, time.Date(2024, 3, 2, 1, 30, 0, 0, time.UTC), true},
+		{"wrapping window, morning", Door{OpenHour: 18, CloseHour: 2}, time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC), false},
+		// 03:00 UTC is 22:00 the day before in New York, inside 18-02
+		{"wrapping window in location", Door{OpenHour: 18, CloseHour: 2, Location: ny}, time.Date(2024, 3, 2, 3, 0, 0, 0, time.UTC), true},
+		// 08:00 UTC is 03:00 in New York, just past the close
+		{"past close in location", Door{OpenHour: 18, CloseHour: 2, Location: ny}, time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC), false},
+		// 20:00 UTC is 15:00 in New York, before it opens
+		{"open in utc but not in location", Door{OpenHour: 18, CloseHour: 2, Location: ny}, time.Date(2024, 3, 2, 20, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.door.Allowed(tt.at); got != tt.allowed {
+				t.Errorf("Allowed(%s) = %t, want %t", tt.at, got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestClientDoorUsesLocation(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tzdata: %s", err)
+	}
+	c := &Client{Doors: []Door{{ID: "front", OpenHour: 18, CloseHour: 2}}, Location: ny}
+	d, ok := c.Door("front")
+	if !ok || d.Location != ny {
+		t.Fatalf("Door = %+v, %t", d, ok)
+	}
+	if !d.Allowed(time.Date(2024, 3, 2, 3, 0, 0, 0, time.UTC)) {
+		t.Error("door should follow the client's location")
+	}
+	if _, ok := c.Door("back"); ok {
+		t.Error("found a door that isn't configured")
+	}
+}
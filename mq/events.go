@@ -0,0 +1,154 @@
+package mq
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+const (
+	EventMemberLinked       = "member_linked"
+	EventMembershipLapsed   = "membership_lapsed"
+	EventStorageCodeIssued  = "storage_code_issued"
+	EventReconcileCompleted = "reconcile_completed"
+)
+
+const defaultTopicPrefix = "tfi"
+const snapshotTopicSuffix = "/members/current"
+
+type EventsConfig struct {
+	// root of the topic hierarchy, e.g. "tfi" gives "tfi/events/member_linked"
+	Prefix string `mapstructure:"prefix"`
+	// per event type overrides of the full topic
+	Topics map[string]string `mapstructure:"topics"`
+	// retained current member list, defaults to "<prefix>/members/current"
+	SnapshotTopic string `mapstructure:"snapshotTopic"`
+}
+
+type Event struct {
+	Type      string            `json:"type"`
+	Time      time.Time         `json:"time"`
+	Member    *Member           `json:"member,omitempty"`
+	Storage   *StorageEvent     `json:"storage,omitempty"`
+	Reconcile *ReconcileSummary `json:"reconcile,omitempty"`
+}
+
+type Member struct {
+	ContactID         string `json:"contactId,omitempty"`
+	Barcode           string `json:"barcode,omitempty"`
+	DisplayName       string `json:"displayName,omitempty"`
+	DiscordID         string `json:"discordId,omitempty"`
+	Email             string `json:"email,omitempty"`
+	MembershipEndDate string `json:"membershipEndDate,omitempty"`
+}
+
+// StorageEvent deliberately leaves out the code itself
+type StorageEvent struct {
+	Lock    string    `json:"lock"`
+	ValidTo time.Time `json:"validTo"`
+}
+
+type ReconcileSummary struct {
+	DryRun    bool          `json:"dryRun"`
+	Duration  time.Duration `json:"duration"`
+	Additions int           `json:"additions"`
+	Deletions int           `json:"deletions"`
+	Errors    int           `json:"errors"`
+}
+
+type MemberSnapshot struct {
+	Updated time.Time `json:"updated"`
+	Members []Member  `json:"members"`
+}
+
+func MemberFromContact(c sfdc.Contact) Member {
+	return Member{
+		ContactID:         c.ID,
+		Barcode:           c.Barcode,
+		DisplayName:       c.DisplayName,
+		DiscordID:         c.DiscordID,
+		Email:             c.Email,
+		MembershipEndDate: c.MembershipEndDate,
+	}
+}
+
+func (c *Client) PublishMemberLinked(contact sfdc.Contact) error {
+	m := MemberFromContact(contact)
+	return c.PublishEvent(Event{Type: EventMemberLinked, Member: &m})
+}
+
+func (c *Client) PublishMembershipLapsed(m Member) error {
+	return c.PublishEvent(Event{Type: EventMembershipLapsed, Member: &m})
+}
+
+func (c *Client) PublishStorageCodeIssued(contact sfdc.Contact, lock string, validTo time.Time) error {
+	m := MemberFromContact(contact)
+	return c.PublishEvent(Event{
+		Type:    EventStorageCodeIssued,
+		Member:  &m,
+		Storage: &StorageEvent{Lock: lock, ValidTo: validTo},
+	})
+}
+
+func (c *Client) PublishReconcileCompleted(summary ReconcileSummary) error {
+	return c.PublishEvent(Event{Type: EventReconcileCompleted, Reconcile: &summary})
+}
+
+func (c *Client) PublishEvent(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", e.Type, err)
+	}
+	return c.publish(c.eventTopic(e.Type), 1, false, payload)
+}
+
+// PublishMemberSnapshot replaces the retained list of current members so
+// devices can authorize badge scans without calling out to Salesforce
+func (c *Client) PublishMemberSnapshot(contacts []sfdc.Contact) error {
+	snapshot := MemberSnapshot{
+		Updated: time.Now(),
+		Members: []Member{},
+	}
+	for _, contact := range contacts {
+		if len(contact.Barcode) == 0 {
+			continue
+		}
+		snapshot.Members = append(snapshot.Members, Member{
+			ContactID:         contact.ID,
+			Barcode:           contact.Barcode,
+			DisplayName:       contact.DisplayName,
+			MembershipEndDate: contact.MembershipEndDate,
+		})
+	}
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal member snapshot: %w", err)
+	}
+	return c.publish(c.snapshotTopic(), 1, true, payload)
+}
+
+func (c *Client) eventTopic(eventType string) string {
+	if t, ok := c.events.Topics[eventType]; ok && len(t) > 0 {
+		return t
+	}
+	return c.topicPrefix() + "/events/" + eventType
+}
+
+func (c *Client) snapshotTopic() string {
+	if len(c.events.SnapshotTopic) > 0 {
+		return c.events.SnapshotTopic
+	}
+	return c.topicPrefix() + snapshotTopicSuffix
+}
+
+func (c *Client) topicPrefix() string {
+	if len(c.events.Prefix) > 0 {
+		return c.events.Prefix
+	}
+	return defaultTopicPrefix
+}
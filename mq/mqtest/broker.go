@@ -0,0 +1,222 @@
+// Package mqtest runs an in-process MQTT broker so code using the mq package
+// can be exercised without the real broker on the shop network.
+package mqtest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/rs/zerolog"
+)
+
+const listenerID = "mqtest"
+
+type Message struct {
+	ClientID string
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+}
+
+type Broker struct {
+	// URL to hand to mq.Config.Broker
+	URL string
+
+	server    *mqtt.Server
+	closeOnce sync.Once
+	closeErr  error
+	mu        sync.Mutex
+	messages  []Message
+	// closed and replaced every time a message is recorded
+	published chan struct{}
+}
+
+// NewBroker starts a broker listening on a random local port
+func NewBroker() (*Broker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	logger := zerolog.Nop()
+	b := &Broker{
+		URL:       "tcp://" + ln.Addr().String(),
+		server:    mqtt.New(&mqtt.Options{Logger: &logger}),
+		published: make(chan struct{}),
+	}
+
+	err = b.server.AddHook(new(auth.AllowHook), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add auth hook: %w", err)
+	}
+	err = b.server.AddHook(&recorder{broker: b}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add recorder hook: %w", err)
+	}
+	err = b.server.AddListener(&listener{ln: ln})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add listener: %w", err)
+	}
+	err = b.server.Serve()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start broker: %w", err)
+	}
+
+	return b, nil
+}
+
+// Close stops the broker. It's safe to call more than once, so tests can
+// close it early to simulate an outage and still close it in cleanup.
+func (b *Broker) Close() error {
+	b.closeOnce.Do(func() {
+		b.closeErr = b.server.Close()
+	})
+	return b.closeErr
+}
+
+// Messages returns every message published by a client so far
+func (b *Broker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.messages...)
+}
+
+// MessagesOn returns the messages published to a single topic
+func (b *Broker) MessagesOn(topic string) []Message {
+	var msgs []Message
+	for _, m := range b.Messages() {
+		if m.Topic == topic {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// WaitForMessage blocks until a message is published to topic, returning the
+// first one seen. Messages published before the call count too.
+func (b *Broker) WaitForMessage(topic string, timeout time.Duration) (Message, error) {
+	deadline := time.After(timeout)
+	for {
+		b.mu.Lock()
+		for _, m := range b.messages {
+			if m.Topic == topic {
+				b.mu.Unlock()
+				return m, nil
+			}
+		}
+		published := b.published
+		b.mu.Unlock()
+
+		select {
+		case <-published:
+		case <-deadline:
+			return Message{}, fmt.Errorf("no message published to %s within %s", topic, timeout)
+		}
+	}
+}
+
+// Publish sends a message as if it came from a device, e.g. to acknowledge a command
+func (b *Broker) Publish(topic string, payload []byte, retain bool, qos byte) error {
+	return b.server.Publish(topic, payload, retain, qos)
+}
+
+// Connected reports whether a client with the given ID is currently connected
+func (b *Broker) Connected(clientID string) bool {
+	cl, ok := b.server.Clients.Get(clientID)
+	return ok && !cl.Closed()
+}
+
+// Disconnect drops a client's connection without a DISCONNECT packet, which
+// looks the same to the client as a network failure
+func (b *Broker) Disconnect(clientID string) error {
+	cl, ok := b.server.Clients.Get(clientID)
+	if !ok {
+		return fmt.Errorf("client %s is not connected", clientID)
+	}
+	cl.Stop(errors.New("disconnected by mqtest"))
+	return nil
+}
+
+func (b *Broker) record(m Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.messages = append(b.messages, m)
+	close(b.published)
+	b.published = make(chan struct{})
+}
+
+type recorder struct {
+	mqtt.HookBase
+	broker *Broker
+}
+
+func (r *recorder) ID() string {
+	return "mqtest-recorder"
+}
+
+func (r *recorder) Provides(b byte) bool {
+	return b == mqtt.OnPublished
+}
+
+func (r *recorder) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	r.broker.record(Message{
+		ClientID: cl.ID,
+		Topic:    pk.TopicName,
+		Payload:  append([]byte(nil), pk.Payload...),
+		QoS:      pk.FixedHeader.Qos,
+		Retain:   pk.FixedHeader.Retain,
+	})
+}
+
+// listener wraps an already bound net.Listener so the random port is known
+// before the broker starts
+type listener struct {
+	ln     net.Listener
+	mu     sync.Mutex
+	closed bool
+}
+
+func (l *listener) Init(*zerolog.Logger) error {
+	return nil
+}
+
+func (l *listener) Serve(establish listeners.EstablishFn) {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			return
+		}
+		go establish(listenerID, conn)
+	}
+}
+
+func (l *listener) ID() string {
+	return listenerID
+}
+
+func (l *listener) Address() string {
+	return l.ln.Addr().String()
+}
+
+func (l *listener) Protocol() string {
+	return "tcp"
+}
+
+func (l *listener) Close(closeClients listeners.CloseFn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	l.closed = true
+	closeClients(listenerID)
+	l.ln.Close()
+}
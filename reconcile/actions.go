@@ -0,0 +1,217 @@
+package reconcile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+const (
+	ActionAdd    = "add"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+const (
+	TargetCheckMeIn     = "checkmein"
+	targetGroupPrefix   = "groups:"
+	targetDiscordPrefix = "discord:"
+)
+
+// Action is one row of a reconcile diff
+type Action struct {
+	Target string `json:"target" csv:"target"`
+	Action string `json:"action" csv:"action"`
+	// email for groups, user ID for Discord, barcode for CheckMeIn
+	Identifier       string `json:"identifier" csv:"identifier"`
+	ContactID        string `json:"contactId" csv:"contact_id"`
+	DisplayName      string `json:"displayName" csv:"display_name"`
+	MembershipStatus string `json:"membershipStatus" csv:"membership_status"`
+	EndDate          string `json:"endDate" csv:"end_date"`
+	Reason           string `json:"reason" csv:"reason"`
+
+	// set when applying the action failed
+	failed bool
+}
+
+// diff collects the actions a run takes, with whatever we know about each contact
+type diff struct {
+	idx     contactIndex
+	actions []Action
+}
+
+// add records an action. name is what the target calls them, used when
+// there's no contact.
+func (d *diff) add(target, action, identifier, name, reason string) {
+	a := Action{
+		Target:      target,
+		Action:      action,
+		Identifier:  identifier,
+		DisplayName: name,
+		Reason:      reason,
+	}
+	c := d.idx.lookup(target, identifier)
+	if c != nil {
+		a.setContact(*c)
+	}
+	if action == ActionDelete {
+		a.Reason = lapsedReason(c)
+	}
+	d.actions = append(d.actions, a)
+}
+
+// fail marks the action for identifier as failed, so metrics only count it
+// as a failure
+func (d *diff) fail(target, identifier string) {
+	for i := range d.actions {
+		a := &d.actions[i]
+		if a.Target == target && a.Identifier == identifier && !a.failed {
+			a.failed = true
+			return
+		}
+	}
+}
+
+func (a *Action) setContact(c sfdc.Contact) {
+	a.ContactID = c.ID
+	a.DisplayName = c.DisplayName
+	a.MembershipStatus = c.MembershipStatus
+	a.EndDate = c.MembershipEndDate
+}
+
+func groupTarget(group string) string {
+	return targetGroupPrefix + group
+}
+
+func discordTarget(guild string) string {
+	return targetDiscordPrefix + guild
+}
+
+func lapsedReason(contact *sfdc.Contact) string {
+	if contact == nil || len(contact.MembershipStatus) == 0 {
+		return "not a current member"
+	}
+	return fmt.Sprintf("not a current member (%s)", contact.MembershipStatus)
+}
+
+// contactIndex finds the SFDC contact behind a group address, Discord user or barcode
+type contactIndex struct {
+	byGroupKey  map[string]sfdc.Contact
+	byDiscordID map[string]sfdc.Contact
+	byBarcode   map[string]sfdc.Contact
+}
+
+func newContactIndex(contacts []sfdc.Contact) contactIndex {
+	idx := contactIndex{
+		byGroupKey:  make(map[string]sfdc.Contact),
+		byDiscordID: make(map[string]sfdc.Contact),
+		byBarcode:   make(map[string]sfdc.Contact),
+	}
+	idx.add(contacts)
+	return idx
+}
+
+func (idx contactIndex) add(contacts []sfdc.Contact) {
+	for _, c := range contacts {
+		for _, email := range []string{c.GroupEmail, c.GroupEmailAlt} {
+			if len(email) > 0 {
+				idx.byGroupKey[groupKey(email)] = c
+			}
+		}
+		if len(c.DiscordID) > 0 {
+			idx.byDiscordID[c.DiscordID] = c
+		}
+		if len(c.Barcode) > 0 {
+			idx.byBarcode[c.Barcode] = c
+		}
+	}
+}
+
+func (idx contactIndex) lookup(target, identifier string) *sfdc.Contact {
+	var c sfdc.Contact
+	var ok bool
+	switch {
+	case target == TargetCheckMeIn:
+		c, ok = idx.byBarcode[identifier]
+	case strings.HasPrefix(target, targetGroupPrefix):
+		c, ok = idx.byGroupKey[groupKey(identifier)]
+	case strings.HasPrefix(target, targetDiscordPrefix):
+		c, ok = idx.byDiscordID[identifier]
+	}
+	if !ok {
+		return nil
+	}
+	return &c
+}
+
+// fillContacts looks up contacts for actions that don't have one yet, which
+// is mostly lapsed members in a full run. It's best effort, the diff is still
+// useful without them.
+func (r *Reconciler) fillContacts(actions []Action, idx contactIndex) {
+	var emails, discordIDs, barcodes []string
+	for _, a := range actions {
+		if len(a.ContactID) > 0 {
+			continue
+		}
+		switch {
+		case a.Target == TargetCheckMeIn:
+			barcodes = append(barcodes, a.Identifier)
+		case strings.HasPrefix(a.Target, targetGroupPrefix):
+			emails = append(emails, a.Identifier)
+		case strings.HasPrefix(a.Target, targetDiscordPrefix):
+			discordIDs = append(discordIDs, a.Identifier)
+		}
+	}
+
+	lookups := []struct {
+		kind   string
+		values []string
+		find   func([]string) ([]sfdc.Contact, error)
+	}{
+		{"group emails", emails, r.SFClient.FindContactsByGroupEmails},
+		{"discord ids", discordIDs, r.SFClient.FindContactsByDiscordIDs},
+		{"barcodes", barcodes, r.SFClient.FindContactsByBarcodes},
+	}
+	found := false
+	for _, l := range lookups {
+		if len(l.values) == 0 {
+			continue
+		}
+		contacts, err := l.find(l.values)
+		if err != nil {
+			r.Logger.Warnf("Failed to look up contacts by %s for reconcile diff: %s", l.kind, err)
+			continue
+		}
+		idx.add(contacts)
+		found = true
+	}
+	if !found {
+		return
+	}
+	for i := range actions {
+		if len(actions[i].ContactID) > 0 {
+			continue
+		}
+		if c := idx.lookup(actions[i].Target, actions[i].Identifier); c != nil {
+			actions[i].setContact(*c)
+			if actions[i].Action == ActionDelete {
+				actions[i].Reason = lapsedReason(c)
+			}
+		}
+	}
+}
+
+func sortActions(actions []Action) {
+	sort.SliceStable(actions, func(i, j int) bool {
+		a, b := actions[i], actions[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		return a.Identifier < b.Identifier
+	})
+}
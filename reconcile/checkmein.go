@@ -0,0 +1,170 @@
+package reconcile
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/checkmein"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+// reconcileCheckMeIn diffs the CheckMeIn roster against SFDC by barcode. Lapsed
+// members are deactivated by backdating their end date, since that's what
+// CheckMeIn uses to decide who's active. Configured exceptions and accounts
+// that never had an end date aren't members, so they're left alone.
+func (r *Reconciler) reconcileCheckMeIn(contactList []sfdc.Contact, s *scope, d *diff, dryRun bool) Changes {
+	changes := Changes{
+		Additions: []string{},
+		Deletions: []string{},
+	}
+
+	exceptions := make(map[string]bool, len(r.CheckMeInExceptions))
+	for _, barcode := range r.CheckMeInExceptions {
+		exceptions[barcode] = true
+	}
+	roster, err := r.checkMeInRoster(s)
+	if err != nil {
+		r.Logger.Errorf("Failed to retrieve checkmein members, bulk adding every current member instead: %s", err)
+		return r.bulkAddCheckMeIn(contactList, exceptions, dryRun, changes)
+	}
+	existing := make(map[string]checkmein.BulkAddMember, len(roster))
+	for _, m := range roster {
+		if s.hasBarcode(m.Barcode) {
+			existing[m.Barcode] = m
+		}
+	}
+
+	var rows []checkmein.BulkAddMember
+	var pending, pendingBarcodes []string
+	current := make(map[string]bool, len(contactList))
+	for _, contact := range contactList {
+		if len(contact.Barcode) == 0 || exceptions[contact.Barcode] {
+			continue
+		}
+		current[contact.Barcode] = true
+		row, err := checkmein.MemberFromContact(contact)
+		if err != nil {
+			r.Logger.Errorf("Skipping %s for checkmein: %s", contact.DisplayName, err)
+			changes.Errored = append(changes.Errored, checkMeInLabel(contact.DisplayName, contact.Barcode))
+			continue
+		}
+		m, ok := existing[contact.Barcode]
+		if !ok {
+			changes.Additions = append(changes.Additions, checkMeInLabel(row.DisplayName, row.Barcode))
+			d.add(TargetCheckMeIn, ActionAdd, row.Barcode, row.DisplayName, "current member not in checkmein")
+		} else if !sameEndDate(m, row) {
+			changes.Updates = append(changes.Updates, checkMeInLabel(row.DisplayName, row.Barcode))
+			d.add(TargetCheckMeIn, ActionUpdate, row.Barcode, row.DisplayName, fmt.Sprintf("end date %s should be %s", m.MembershipEndDate, row.MembershipEndDate))
+		} else {
+			continue
+		}
+		rows = append(rows, row)
+		pending = append(pending, checkMeInLabel(row.DisplayName, row.Barcode))
+		pendingBarcodes = append(pendingBarcodes, row.Barcode)
+	}
+
+	// parsed end dates are midnight UTC
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for barcode, m := range existing {
+		if current[barcode] || exceptions[barcode] || len(m.MembershipEndDate) == 0 {
+			continue
+		}
+		end, err := m.EndDate()
+		// already expired in checkmein, nothing to do
+		if err == nil && end.Before(today) {
+			continue
+		}
+		m.MembershipEndDate = today.AddDate(0, 0, -1).Format(checkmein.BulkAddDateFormat)
+		changes.Deletions = append(changes.Deletions, checkMeInLabel(m.DisplayName, m.Barcode))
+		d.add(TargetCheckMeIn, ActionDelete, m.Barcode, m.DisplayName, "")
+		rows = append(rows, m)
+		pending = append(pending, checkMeInLabel(m.DisplayName, m.Barcode))
+		pendingBarcodes = append(pendingBarcodes, m.Barcode)
+	}
+
+	if dryRun || len(rows) == 0 {
+		return changes
+	}
+	err = r.uploadCheckMeIn(rows)
+	if err != nil {
+		r.Logger.Errorf("Failed to sync %d members to checkmein: %s", len(rows), err)
+		changes.Errored = append(changes.Errored, pending...)
+		for _, barcode := range pendingBarcodes {
+			d.fail(TargetCheckMeIn, barcode)
+		}
+		return changes
+	}
+	r.Logger.Infof("Synced %d members to checkmein", len(rows))
+	return changes
+}
+
+// bulkAddCheckMeIn is the fallback when the roster can't be exported. Like
+// before the diff existed, every current member is uploaded, but nobody can
+// be deactivated without knowing who's in CheckMeIn.
+func (r *Reconciler) bulkAddCheckMeIn(contactList []sfdc.Contact, exceptions map[string]bool, dryRun bool, changes Changes) Changes {
+	changes.Error = "failed to retrieve checkmein members, lapsed members weren't deactivated"
+	var rows []checkmein.BulkAddMember
+	for _, contact := range contactList {
+		if len(contact.Barcode) == 0 || exceptions[contact.Barcode] {
+			continue
+		}
+		row, err := checkmein.MemberFromContact(contact)
+		if err != nil {
+			r.Logger.Errorf("Skipping %s for checkmein: %s", contact.DisplayName, err)
+			changes.Errored = append(changes.Errored, checkMeInLabel(contact.DisplayName, contact.Barcode))
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	if dryRun || len(rows) == 0 {
+		return changes
+	}
+	err := r.uploadCheckMeIn(rows)
+	if err != nil {
+		r.Logger.Errorf("Failed to bulk add %d members to checkmein: %s", len(rows), err)
+		changes.Error = "failed to retrieve or bulk add checkmein members"
+		return changes
+	}
+	r.Logger.Infof("Bulk added %d members to checkmein", len(rows))
+	return changes
+}
+
+// uploadCheckMeIn goes through the roster cache when there is one, so it
+// stays up to date
+func (r *Reconciler) uploadCheckMeIn(rows []checkmein.BulkAddMember) error {
+	if r.CheckMeInRoster != nil {
+		return r.CheckMeInRoster.Upload(rows)
+	}
+	return r.CheckMeInClient.Upload(rows)
+}
+
+// checkMeInRoster exports every member for full runs. Scoped runs use the
+// roster cache when there is one.
+func (r *Reconciler) checkMeInRoster(s *scope) ([]checkmein.BulkAddMember, error) {
+	if r.CheckMeInRoster == nil {
+		return r.CheckMeInClient.ListMembers()
+	}
+	if s == nil {
+		return r.CheckMeInRoster.Refresh()
+	}
+	barcodes := make([]string, 0, len(s.barcodes))
+	for barcode := range s.barcodes {
+		barcodes = append(barcodes, barcode)
+	}
+	return r.CheckMeInRoster.Lookup(barcodes)
+}
+
+func sameEndDate(a, b checkmein.BulkAddMember) bool {
+	aEnd, aErr := a.EndDate()
+	bEnd, bErr := b.EndDate()
+	if aErr != nil || bErr != nil {
+		return false
+	}
+	return aEnd.Equal(bEnd)
+}
+
+func checkMeInLabel(name, barcode string) string {
+	return fmt.Sprintf("%s (%s)", name, barcode)
+}
@@ -0,0 +1,153 @@
+package reconcile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/checkmein"
+	"github.com/theforgeinitiative/integrations/checkmein/checkmeintest"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+func newCheckMeInReconciler(t *testing.T, roster []checkmein.BulkAddMember) (*Reconciler, *checkmeintest.Server) {
+	t.Helper()
+	srv := checkmeintest.NewServer("admin", "secret")
+	t.Cleanup(srv.Close)
+	srv.SetMembers(roster)
+
+	cc := checkmein.NewClient(srv.URL, "admin", "secret")
+	return &Reconciler{
+		CheckMeInClient:     &cc,
+		CheckMeInExceptions: []string{"900"},
+		Logger:              testLogger{t},
+	}, srv
+}
+
+func endDates(srv *checkmeintest.Server) map[string]string {
+	dates := make(map[string]string)
+	for _, m := range srv.Members() {
+		dates[m.Barcode] = m.MembershipEndDate
+	}
+	return dates
+}
+
+func TestReconcileCheckMeIn(t *testing.T) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	nextYear := today.AddDate(1, 0, 0)
+	yesterday := today.AddDate(0, 0, -1).Format(checkmein.BulkAddDateFormat)
+
+	r, srv := newCheckMeInReconciler(t, []checkmein.BulkAddMember{
+		{Barcode: "100", DisplayName: "Same", MembershipEndDate: nextYear.Format(checkmein.BulkAddDateFormat)},
+		{Barcode: "200", DisplayName: "Renewed", MembershipEndDate: "1/31/2020"},
+		{Barcode: "300", DisplayName: "Lapsed", MembershipEndDate: nextYear.Format(checkmein.BulkAddDateFormat)},
+		{Barcode: "400", DisplayName: "Expired", MembershipEndDate: "1/31/2020"},
+		{Barcode: "500", DisplayName: "Kiosk"},
+		{Barcode: "900", DisplayName: "Staff", MembershipEndDate: nextYear.Format(checkmein.BulkAddDateFormat)},
+	})
+	end := nextYear.Format(sfdc.DateFormat)
+	contacts := []sfdc.Contact{
+		{ID: "1", Barcode: "100", DisplayName: "Same", MembershipEndDate: end},
+		{ID: "2", Barcode: "200", DisplayName: "Renewed", MembershipEndDate: end},
+		{ID: "6", Barcode: "600", DisplayName: "New", MembershipEndDate: end},
+		{ID: "7", DisplayName: "No barcode", MembershipEndDate: end},
+	}
+	d := &diff{idx: newContactIndex(contacts)}
+
+	changes := r.reconcileCheckMeIn(contacts, nil, d, false)
+	if len(changes.Error) > 0 || len(changes.Errored) > 0 {
+		t.Fatalf("changes = %+v", changes)
+	}
+	if len(changes.Additions) != 1 || changes.Additions[0] != "New (600)" {
+		t.Errorf("additions = %v", changes.Additions)
+	}
+	if len(changes.Updates) != 1 || changes.Updates[0] != "Renewed (200)" {
+		t.Errorf("updates = %v", changes.Updates)
+	}
+	if len(changes.Deletions) != 1 || changes.Deletions[0] != "Lapsed (300)" {
+		t.Errorf("deletions = %v", changes.Deletions)
+	}
+	if len(d.actions) != 3 {
+		t.Errorf("diff has %d actions, want 3", len(d.actions))
+	}
+
+	if uploads := srv.Uploads(); len(uploads) != 1 || len(uploads[0]) != 3 {
+		t.Fatalf("uploads = %+v, want one upload of 3 rows", uploads)
+	}
+	want := map[string]string{
+		"100": nextYear.Format(checkmein.BulkAddDateFormat),
+		"200": nextYear.Format(checkmein.BulkAddDateFormat),
+		// lapsed members are deactivated by backdating their end date
+		"300": yesterday,
+		// already expired, no end date, and exceptions are left alone
+		"400": "1/31/2020",
+		"500": "",
+		"900": nextYear.Format(checkmein.BulkAddDateFormat),
+		"600": nextYear.Format(checkmein.BulkAddDateFormat),
+	}
+	got := endDates(srv)
+	for barcode, date := range want {
+		if got[barcode] != date {
+			t.Errorf("%s end date = %q, want %q", barcode, got[barcode], date)
+		}
+	}
+}
+
+func TestReconcileCheckMeInDryRun(t *testing.T) {
+	r, srv := newCheckMeInReconciler(t, []checkmein.BulkAddMember{
+		{Barcode: "300", DisplayName: "Lapsed", MembershipEndDate: "12/31/2099"},
+	})
+	contacts := []sfdc.Contact{{ID: "6", Barcode: "600", DisplayName: "New", MembershipEndDate: "2099-12-31"}}
+	d := &diff{idx: newContactIndex(contacts)}
+
+	changes := r.reconcileCheckMeIn(contacts, nil, d, true)
+	if len(changes.Additions) != 1 || len(changes.Deletions) != 1 {
+		t.Errorf("changes = %+v, want one addition and one deletion", changes)
+	}
+	if uploads := srv.Uploads(); len(uploads) != 0 {
+		t.Errorf("dry run uploaded %+v", uploads)
+	}
+}
+
+func TestReconcileCheckMeInScoped(t *testing.T) {
+	r, srv := newCheckMeInReconciler(t, []checkmein.BulkAddMember{
+		{Barcode: "300", DisplayName: "Lapsed", MembershipEndDate: "12/31/2099"},
+		{Barcode: "800", DisplayName: "Someone else", MembershipEndDate: "12/31/2099"},
+	})
+	// the scope is the lapsed contact, who isn't a current member
+	lapsed := []sfdc.Contact{{ID: "3", Barcode: "300", DisplayName: "Lapsed", MembershipEndDate: "2020-01-31"}}
+	d := &diff{idx: newContactIndex(lapsed)}
+
+	changes := r.reconcileCheckMeIn(nil, newScope(lapsed), d, false)
+	if len(changes.Deletions) != 1 || changes.Deletions[0] != "Lapsed (300)" {
+		t.Errorf("deletions = %v", changes.Deletions)
+	}
+	if got := endDates(srv); got["800"] != "12/31/2099" {
+		t.Errorf("member outside the scope was changed: %v", got)
+	}
+}
+
+func TestReconcileCheckMeInExportFails(t *testing.T) {
+	r, srv := newCheckMeInReconciler(t, []checkmein.BulkAddMember{
+		{Barcode: "300", DisplayName: "Lapsed", MembershipEndDate: "12/31/2099"},
+	})
+	r.CheckMeInClient.MembersExportPath = "/admin/missing"
+	contacts := []sfdc.Contact{
+		{ID: "1", Barcode: "100", DisplayName: "Current", MembershipEndDate: "2099-12-31"},
+		{ID: "9", Barcode: "900", DisplayName: "Staff", MembershipEndDate: "2099-12-31"},
+	}
+	d := &diff{idx: newContactIndex(contacts)}
+
+	changes := r.reconcileCheckMeIn(contacts, nil, d, false)
+	if len(changes.Error) == 0 {
+		t.Error("expected the failed export to be reported")
+	}
+	// every current member is still bulk added, exceptions excluded
+	uploads := srv.Uploads()
+	if len(uploads) != 1 || len(uploads[0]) != 1 || uploads[0][0].Barcode != "100" {
+		t.Fatalf("uploads = %+v, want just barcode 100", uploads)
+	}
+	if got := endDates(srv); got["300"] != "12/31/2099" {
+		t.Errorf("lapsed member changed without a roster: %v", got)
+	}
+}
@@ -0,0 +1,118 @@
+package reconcile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord rejects embeds over these limits
+const (
+	maxEmbedFields     = 25
+	maxEmbedFieldValue = 1024
+	maxEmbedTotal      = 6000
+)
+
+const (
+	embedColorOK    = 0x1a7f37
+	embedColorError = 0xcf222e
+)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "~", `\~`, "|", `\|`, ">", `\>`, "#", `\#`, "[", `\[`, "]", `\]`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// DiscordEmbed summarizes the report with one field per target that changed
+func (r Report) DiscordEmbed() *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:     "TFI Integrations Reconciliation Report",
+		Timestamp: r.Date.Format(time.RFC3339),
+		Color:     embedColorOK,
+		Footer:    &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Execution time: %s", r.Duration)},
+	}
+	size := len(embed.Title) + len(embed.Footer.Text)
+
+	add := func(name string, c Changes) {
+		if len(c.Errored) > 0 || len(c.Error) > 0 {
+			embed.Color = embedColorError
+		}
+		if !c.HasChanges() {
+			return
+		}
+		value := embedFieldValue(c)
+		if len(embed.Fields) >= maxEmbedFields || size+len(name)+len(value) > maxEmbedTotal {
+			return
+		}
+		size += len(name) + len(value)
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: name, Value: value})
+	}
+
+	add("CheckMeIn", r.CheckMeIn)
+	for _, name := range sortedKeys(r.Groups) {
+		add("Google Group: "+name, r.Groups[name])
+	}
+	for _, name := range sortedKeys(r.Discord) {
+		add("Discord: "+name, r.Discord[name])
+	}
+
+	if len(embed.Fields) == 0 {
+		embed.Description = "No changes."
+	}
+	return embed
+}
+
+func embedFieldValue(c Changes) string {
+	var lines []string
+	if len(c.Error) > 0 {
+		lines = append(lines, "**Sync failed:** "+escapeMarkdown(c.Error))
+	}
+	for _, section := range []struct {
+		label string
+		names []string
+	}{
+		{"Added", c.Additions},
+		{"Updated", c.Updates},
+		{"Removed", c.Deletions},
+		{"Errors", c.Errored},
+	} {
+		if len(section.names) == 0 {
+			continue
+		}
+		escaped := make([]string, len(section.names))
+		for i, n := range section.names {
+			escaped[i] = escapeMarkdown(n)
+		}
+		lines = append(lines, fmt.Sprintf("**%s (%d):** %s", section.label, len(section.names), strings.Join(escaped, ", ")))
+	}
+	return truncate(strings.Join(lines, "\n"), maxEmbedFieldValue)
+}
+
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	const ellipsis = "…"
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n-len(ellipsis)]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s + ellipsis
+}
+
+func sortedKeys(m map[string]Changes) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
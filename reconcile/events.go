@@ -0,0 +1,52 @@
+package reconcile
+
+import "github.com/theforgeinitiative/integrations/mq"
+
+// publishEvents lets devices on the shop network know about membership changes.
+// The snapshot only makes sense when the run covered every member.
+func (r *Reconciler) publishEvents(result Result, snapshot bool) {
+	report := result.Report
+	var err error
+	if snapshot {
+		err = r.MQClient.PublishMemberSnapshot(result.Contacts)
+		if err != nil {
+			r.Logger.Warnf("Failed to publish member snapshot: %s", err)
+		}
+	}
+
+	for _, changes := range report.Groups {
+		for _, email := range changes.Deletions {
+			err = r.MQClient.PublishMembershipLapsed(mq.Member{Email: email})
+			if err != nil {
+				r.Logger.Warnf("Failed to publish lapsed event for %s: %s", email, err)
+			}
+		}
+	}
+	for _, id := range result.LapsedDiscordIDs {
+		m := mq.Member{DiscordID: id}
+		// the contact still exists, it's just no longer current
+		if contact, err := r.SFClient.GetContactByDiscordID(id); err == nil {
+			m = mq.MemberFromContact(contact)
+		}
+		err = r.MQClient.PublishMembershipLapsed(m)
+		if err != nil {
+			r.Logger.Warnf("Failed to publish lapsed event for discord user %s: %s", id, err)
+		}
+	}
+
+	summary := mq.ReconcileSummary{Duration: report.Duration}
+	for _, changes := range report.Groups {
+		summary.Additions += len(changes.Additions)
+		summary.Deletions += len(changes.Deletions)
+		summary.Errors += len(changes.Errored)
+	}
+	for _, changes := range report.Discord {
+		summary.Additions += len(changes.Additions)
+		summary.Deletions += len(changes.Deletions)
+		summary.Errors += len(changes.Errored)
+	}
+	err = r.MQClient.PublishReconcileCompleted(summary)
+	if err != nil {
+		r.Logger.Warnf("Failed to publish reconcile completed event: %s", err)
+	}
+}
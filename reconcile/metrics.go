@@ -0,0 +1,27 @@
+package reconcile
+
+import "github.com/theforgeinitiative/integrations/metrics"
+
+// recordMetrics counts an applied run's changes. Errored changes are only
+// counted as failed, not under their action.
+func recordMetrics(result Result, s *scope) {
+	kind := "contacts"
+	if s == nil {
+		kind = "full"
+	}
+	metrics.ReconcileDuration.WithLabelValues(kind).Observe(result.Report.Duration.Seconds())
+
+	for _, a := range result.Actions {
+		if a.failed {
+			continue
+		}
+		metrics.ReconcileChanges.WithLabelValues(a.Target, a.Action).Inc()
+	}
+	metrics.ReconcileChanges.WithLabelValues(TargetCheckMeIn, "failed").Add(float64(len(result.Report.CheckMeIn.Errored)))
+	for group, changes := range result.Report.Groups {
+		metrics.ReconcileChanges.WithLabelValues(groupTarget(group), "failed").Add(float64(len(changes.Errored)))
+	}
+	for guild, changes := range result.Report.Discord {
+		metrics.ReconcileChanges.WithLabelValues(discordTarget(guild), "failed").Add(float64(len(changes.Errored)))
+	}
+}
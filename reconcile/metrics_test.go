@@ -0,0 +1,31 @@
+package reconcile
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/theforgeinitiative/integrations/metrics"
+)
+
+func TestRecordMetricsCountsFailuresOnce(t *testing.T) {
+	target := groupTarget("metrics-test")
+	d := &diff{idx: newContactIndex(nil)}
+	d.add(target, ActionAdd, "ok@example.com", "", "")
+	d.add(target, ActionAdd, "bad@example.com", "", "")
+	d.fail(target, "bad@example.com")
+
+	result := Result{
+		Actions: d.actions,
+		Report: Report{
+			Groups: map[string]Changes{"metrics-test": {Errored: []string{"bad@example.com"}}},
+		},
+	}
+	recordMetrics(result, nil)
+
+	if got := testutil.ToFloat64(metrics.ReconcileChanges.WithLabelValues(target, ActionAdd)); got != 1 {
+		t.Errorf("expected 1 add, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.ReconcileChanges.WithLabelValues(target, "failed")); got != 1 {
+		t.Errorf("expected 1 failure, got %v", got)
+	}
+}
@@ -0,0 +1,506 @@
+package reconcile
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/checkmein"
+	"github.com/theforgeinitiative/integrations/discord"
+	"github.com/theforgeinitiative/integrations/groups"
+	"github.com/theforgeinitiative/integrations/mq"
+	"github.com/theforgeinitiative/integrations/sfdc"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// Logger is satisfied by echo.Logger
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// StdLogger sends reconcile logs to the standard logger, for callers without echo
+type StdLogger struct{}
+
+func (StdLogger) Infof(format string, args ...interface{})  { log.Printf(format, args...) }
+func (StdLogger) Warnf(format string, args ...interface{})  { log.Printf(format, args...) }
+func (StdLogger) Errorf(format string, args ...interface{}) { log.Printf(format, args...) }
+
+// DefaultManagerStatus is the campaign member status that makes a board member a manager
+const DefaultManagerStatus = "Active"
+
+// Reconciler brings the members group, Discord roles and CheckMeIn in line with SFDC
+type Reconciler struct {
+	SFClient        *sfdc.Client
+	GroupsClient    *groups.Client
+	GroupExceptions []string
+	// CheckMeIn barcodes that aren't in SFDC, like staff and kiosk accounts
+	CheckMeInExceptions []string
+	// contacts in this SFDC campaign become managers of the members group
+	ManagerCampaign string
+	// only campaign members with this status count, defaults to DefaultManagerStatus
+	ManagerStatus   string
+	DiscordClient   *discord.Client
+	CheckMeInClient *checkmein.Client
+	// optional, single-contact runs use it instead of exporting the roster
+	CheckMeInRoster *checkmein.RosterCache
+	// optional, events are skipped when nil
+	MQClient *mq.Client
+	Logger   Logger
+}
+
+type Result struct {
+	Report Report
+	// one row per change, sorted by target
+	Actions []Action
+	// the current members the run worked from
+	Contacts []sfdc.Contact
+	// users whose Discord member role was removed
+	LapsedDiscordIDs []string
+}
+
+// scope limits a run to a few contacts. A nil scope covers everyone.
+type scope struct {
+	groupEmails map[string]string
+	discordIDs  map[string]bool
+	barcodes    map[string]bool
+}
+
+func newScope(contacts []sfdc.Contact) *scope {
+	s := &scope{
+		groupEmails: make(map[string]string),
+		discordIDs:  make(map[string]bool),
+		barcodes:    make(map[string]bool),
+	}
+	for _, c := range contacts {
+		for _, email := range []string{c.GroupEmail, c.GroupEmailAlt} {
+			if len(email) > 0 {
+				s.groupEmails[groupKey(email)] = email
+			}
+		}
+		if len(c.DiscordID) > 0 {
+			s.discordIDs[c.DiscordID] = true
+		}
+		if len(c.Barcode) > 0 {
+			s.barcodes[c.Barcode] = true
+		}
+	}
+	return s
+}
+
+func (s *scope) hasGroupKey(key string) bool {
+	if s == nil {
+		return true
+	}
+	_, ok := s.groupEmails[key]
+	return ok
+}
+
+func (s *scope) hasBarcode(barcode string) bool {
+	return s == nil || s.barcodes[barcode]
+}
+
+// Run reconciles every current member in SFDC
+func (r *Reconciler) Run(dryRun bool) (Result, error) {
+	start := time.Now()
+	contactList, err := r.SFClient.FindCurrentMembers()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to retrieve current members from sfdc: %w", err)
+	}
+	return r.run(start, contactList, contactList, nil, dryRun)
+}
+
+// RunContacts reconciles only the given contacts, whatever their membership
+// status. Lapsed contacts are removed just like in a full run.
+func (r *Reconciler) RunContacts(contacts []sfdc.Contact, dryRun bool) (Result, error) {
+	start := time.Now()
+	var current []sfdc.Contact
+	for _, c := range contacts {
+		// same filter as FindCurrentMembers
+		if c.CurrentMember() && !c.IsTestContact() {
+			current = append(current, c)
+		}
+	}
+	return r.run(start, current, contacts, newScope(contacts), dryRun)
+}
+
+// run diffs contactList, the current members, against each target. known are
+// all the contacts we already have, for describing the changes.
+func (r *Reconciler) run(start time.Time, contactList, known []sfdc.Contact, s *scope, dryRun bool) (Result, error) {
+	result := Result{
+		Report: Report{
+			Date:    start,
+			Discord: make(map[string]Changes),
+			Groups:  make(map[string]Changes),
+		},
+		Contacts: contactList,
+	}
+
+	// board members manage the members list
+	var managers map[string]bool
+	if len(r.ManagerCampaign) > 0 {
+		var err error
+		status := r.ManagerStatus
+		if len(status) == 0 {
+			status = DefaultManagerStatus
+		}
+		managers, err = r.SFClient.CampaignContactIDs(r.ManagerCampaign, status)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to retrieve group managers from sfdc: %w", err)
+		}
+	}
+
+	d := &diff{idx: newContactIndex(known)}
+
+	// CHECKMEIN
+	result.Report.CheckMeIn = r.reconcileCheckMeIn(contactList, s, d, dryRun)
+
+	// GOOGLE GROUPS
+	changes, err := r.reconcileGroup(contactList, managers, s, d, dryRun)
+	if err != nil {
+		return Result{}, err
+	}
+	result.Report.Groups["members"] = changes
+
+	// DISCORD
+	result.LapsedDiscordIDs, err = r.reconcileDiscord(contactList, s, d, dryRun, result.Report.Discord)
+	if err != nil {
+		return Result{}, err
+	}
+
+	r.fillContacts(d.actions, d.idx)
+	sortActions(d.actions)
+	result.Actions = d.actions
+
+	result.Report.Duration = time.Since(start)
+	if !dryRun {
+		recordMetrics(result, s)
+		if r.MQClient != nil {
+			r.publishEvents(result, s == nil)
+		}
+	}
+	return result, nil
+}
+
+func (r *Reconciler) reconcileGroup(contactList []sfdc.Contact, managers map[string]bool, s *scope, d *diff, dryRun bool) (Changes, error) {
+	contacts := contactEmailMap(contactList, managers)
+	// add exceptions from config
+	r.addExceptions(contacts)
+
+	emails, err := r.groupMembers(s)
+	if err != nil {
+		return Changes{}, err
+	}
+
+	var errored, add, update, del []string
+	var toAdd, toUpdate, toRemove []groups.Member
+
+	// iterate over Salesforce data to find additions and role/delivery changes
+	for key, want := range contacts {
+		if !s.hasGroupKey(key) {
+			continue
+		}
+		member, ok := emails[key]
+		if !ok {
+			add = append(add, want.Email)
+			d.add(groupTarget("members"), ActionAdd, want.Email, "", want.reason("current member not in group"))
+			toAdd = append(toAdd, groups.Member{Email: want.Email, Role: want.Role, Delivery: want.Delivery})
+			continue
+		}
+		role, delivery := groupMemberChanges(member, want)
+		if len(role) == 0 && len(delivery) == 0 {
+			continue
+		}
+		update = append(update, groupLabel(member.Email, role, delivery))
+		d.add(groupTarget("members"), ActionUpdate, member.Email, "", want.reason(groupChangeReason(member, role, delivery)))
+		toUpdate = append(toUpdate, groups.Member{Email: member.Email, Role: role, Delivery: delivery})
+	}
+
+	// iterate over emails to find deletions
+	for key, member := range emails {
+		if _, ok := contacts[key]; !ok {
+			del = append(del, member.Email)
+			d.add(groupTarget("members"), ActionDelete, member.Email, "", "")
+			toRemove = append(toRemove, groups.Member{Email: member.Email})
+		}
+	}
+
+	if !dryRun {
+		errored = append(errored, r.applyGroupChanges("add", toAdd, r.GroupsClient.AddMembers)...)
+		errored = append(errored, r.applyGroupChanges("update", toUpdate, r.GroupsClient.UpdateMembers)...)
+		errored = append(errored, r.applyGroupChanges("remove", toRemove, r.GroupsClient.RemoveMembers)...)
+		for _, email := range errored {
+			d.fail(groupTarget("members"), email)
+		}
+	}
+	return Changes{
+		Additions: add,
+		Updates:   update,
+		Deletions: del,
+		Errored:   errored,
+	}, nil
+}
+
+// groupMembers lists the whole group, or looks up just the scoped addresses
+func (r *Reconciler) groupMembers(s *scope) (map[string]*admin.Member, error) {
+	if s == nil {
+		emailList, err := r.GroupsClient.ListMembers()
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve group members: %w", err)
+		}
+		return groupEmailMap(emailList), nil
+	}
+	members := make(map[string]*admin.Member)
+	for key, email := range s.groupEmails {
+		m, err := r.GroupsClient.LookupMember(email)
+		if groups.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up group member %s: %w", email, err)
+		}
+		members[key] = m
+	}
+	return members, nil
+}
+
+// applyGroupChanges sends a batch of member changes and returns the emails that failed
+func (r *Reconciler) applyGroupChanges(action string, members []groups.Member, apply func([]groups.Member) map[string]error) []string {
+	if len(members) == 0 {
+		return nil
+	}
+	var errored []string
+	errs := apply(members)
+	for _, m := range members {
+		if err, failed := errs[m.Email]; failed {
+			r.Logger.Errorf("Failed to %s %s in members group: %s", action, m.Email, err)
+			errored = append(errored, m.Email)
+			continue
+		}
+		r.Logger.Infof("Finished %s of %s in members group", action, m.Email)
+	}
+	return errored
+}
+
+// reconcileDiscord fills in changes for every configured guild and returns
+// the users whose member role was removed
+func (r *Reconciler) reconcileDiscord(contactList []sfdc.Contact, s *scope, d *diff, dryRun bool, report map[string]Changes) ([]string, error) {
+	discAdd := make(map[string][]string)
+	discDel := make(map[string][]string)
+	discErrored := make(map[string][]string)
+	var lapsedDiscord []string
+	guildMembers, err := r.guildMembers(s)
+	if err != nil {
+		return nil, err
+	}
+	contactsByDiscord := discordContactMap(contactList)
+	for guild, members := range guildMembers {
+		discAdd[guild] = []string{}
+		discDel[guild] = []string{}
+		discErrored[guild] = []string{}
+
+		// Handle additions
+		for key := range contactsByDiscord {
+			m, ok := members[key]
+			if !ok || r.DiscordClient.HasMemberRole(m, guild) {
+				continue
+			}
+			discAdd[guild] = append(discAdd[guild], m.Nick())
+			d.add(discordTarget(guild), ActionAdd, m.ID, m.Nick(), "current member missing member role")
+			if !dryRun {
+				err := r.DiscordClient.AddMemberRole(m.ID, guild)
+				if err != nil {
+					r.Logger.Errorf("Failed to add %s to %s discord member role: %s", m.Nick(), guild, err)
+					discErrored[guild] = append(discErrored[guild], m.Nick())
+					d.fail(discordTarget(guild), m.ID)
+					continue
+				}
+				r.Logger.Infof("Added %s to %s discord member role", m.Nick(), guild)
+			}
+
+		}
+		// Handle deletions
+		for _, m := range members {
+			_, ok := contactsByDiscord[m.ID]
+			if ok || !r.DiscordClient.HasMemberRole(m, guild) {
+				continue
+			}
+			discDel[guild] = append(discDel[guild], m.Nick())
+			d.add(discordTarget(guild), ActionDelete, m.ID, m.Nick(), "")
+			if !dryRun {
+				err := r.DiscordClient.RemoveMemberRole(m.ID, guild)
+				if err != nil {
+					r.Logger.Errorf("Failed to remove %s from %s discord member role: %s", m.Nick(), guild, err)
+					discErrored[guild] = append(discErrored[guild], m.Nick())
+					d.fail(discordTarget(guild), m.ID)
+					continue
+				}
+				r.Logger.Infof("Removed %s from %s discord member role", m.Nick(), guild)
+				lapsedDiscord = append(lapsedDiscord, m.ID)
+			}
+		}
+	}
+	for g := range r.DiscordClient.Guilds {
+		report[g] = Changes{
+			Additions: discAdd[g],
+			Deletions: discDel[g],
+			Errored:   discErrored[g],
+		}
+	}
+	return lapsedDiscord, nil
+}
+
+// guildMembers lists every guild, or looks up just the scoped users
+func (r *Reconciler) guildMembers(s *scope) (map[string]map[string]discord.Member, error) {
+	if s == nil {
+		members, err := r.DiscordClient.GuildMembers()
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve discord guild members: %w", err)
+		}
+		return members, nil
+	}
+	members := make(map[string]map[string]discord.Member)
+	for guild := range r.DiscordClient.Guilds {
+		members[guild] = make(map[string]discord.Member)
+		for id := range s.discordIDs {
+			m, ok, err := r.DiscordClient.GuildMember(guild, id)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				members[guild][id] = m
+			}
+		}
+	}
+	return members, nil
+}
+
+type groupMembership struct {
+	Email     string
+	Role      string
+	Delivery  string
+	Exception bool
+}
+
+func (m groupMembership) reason(reason string) string {
+	if m.Exception {
+		return "configured exception"
+	}
+	return reason
+}
+
+// exceptions aren't in SFDC, so whatever role they have in the group is left alone
+func (r *Reconciler) addExceptions(emails map[string]groupMembership) {
+	for _, e := range r.GroupExceptions {
+		emails[groupKey(e)] = groupMembership{Email: e, Exception: true}
+	}
+}
+
+func contactEmailMap(slice []sfdc.Contact, managers map[string]bool) map[string]groupMembership {
+	contacts := make(map[string]groupMembership, len(slice))
+	for _, c := range slice {
+		role, delivery := groupSettings(c, managers[c.ID])
+		if len(c.GroupEmail) > 0 {
+			contacts[groupKey(c.GroupEmail)] = groupMembership{Email: c.GroupEmail, Role: role, Delivery: delivery}
+		}
+		if len(c.GroupEmailAlt) > 0 {
+			contacts[groupKey(c.GroupEmailAlt)] = groupMembership{Email: c.GroupEmailAlt, Role: role, Delivery: delivery}
+		}
+	}
+	return contacts
+}
+
+// groupSettings maps the SFDC group fields to Google's values. Either is left
+// empty when SFDC doesn't specify one so we don't override what the group has,
+// including roles set by hand. Board members are always at least managers.
+func groupSettings(c sfdc.Contact, manager bool) (string, string) {
+	role := strings.ToUpper(strings.TrimSpace(c.GroupRole))
+	switch role {
+	case groups.RoleMember, groups.RoleManager, groups.RoleOwner:
+	default:
+		role = ""
+	}
+	if manager {
+		role = groups.HigherRole(role, groups.RoleManager)
+	}
+
+	var delivery string
+	switch strings.ToLower(strings.TrimSpace(c.GroupDelivery)) {
+	case "all", "all mail", "all_mail":
+		delivery = groups.DeliveryAllMail
+	case "digest":
+		delivery = groups.DeliveryDigest
+	case "daily":
+		delivery = groups.DeliveryDaily
+	case "none", "no email", "no_email":
+		delivery = groups.DeliveryNone
+	}
+	return role, delivery
+}
+
+// groupMemberChanges returns the role and delivery settings that need to change,
+// or empty strings if they're already correct. Owners are never demoted
+// automatically so nobody gets locked out of managing the group.
+func groupMemberChanges(member *admin.Member, want groupMembership) (string, string) {
+	var role, delivery string
+	if len(want.Role) > 0 && member.Role != want.Role && member.Role != groups.RoleOwner {
+		role = want.Role
+	}
+	if len(want.Delivery) > 0 && member.DeliverySettings != want.Delivery {
+		delivery = want.Delivery
+	}
+	return role, delivery
+}
+
+func groupChangeReason(member *admin.Member, role, delivery string) string {
+	var changes []string
+	if len(role) > 0 {
+		changes = append(changes, fmt.Sprintf("role %s should be %s", member.Role, role))
+	}
+	if len(delivery) > 0 {
+		changes = append(changes, fmt.Sprintf("delivery %s should be %s", member.DeliverySettings, delivery))
+	}
+	return strings.Join(changes, ", ")
+}
+
+func groupLabel(email, role, delivery string) string {
+	var changes []string
+	if len(role) > 0 {
+		changes = append(changes, role)
+	}
+	if len(delivery) > 0 {
+		changes = append(changes, delivery)
+	}
+	return fmt.Sprintf("%s (%s)", email, strings.Join(changes, ", "))
+}
+
+// Gmail likes to "fix" missing dots and capitalization, so we'll normalize them to prevent trying to add duplicates
+func groupKey(email string) string {
+	key := strings.ToLower(email)
+	return strings.ReplaceAll(key, ".", "")
+}
+
+// SameGroupAddress reports whether Google Groups treats a and b as the same member
+func SameGroupAddress(a, b string) bool {
+	return groupKey(a) == groupKey(b)
+}
+
+func groupEmailMap(slice []*admin.Member) map[string]*admin.Member {
+	members := make(map[string]*admin.Member, len(slice))
+	for _, m := range slice {
+		members[groupKey(m.Email)] = m
+	}
+	return members
+}
+
+func discordContactMap(slice []sfdc.Contact) map[string]sfdc.Contact {
+	contacts := make(map[string]sfdc.Contact)
+	for _, c := range slice {
+		if len(c.DiscordID) > 0 {
+			contacts[c.DiscordID] = c
+		}
+	}
+	return contacts
+}
@@ -0,0 +1,202 @@
+package reconcile
+
+import (
+	"testing"
+
+	"github.com/theforgeinitiative/integrations/googletest"
+	"github.com/theforgeinitiative/integrations/groups"
+	"github.com/theforgeinitiative/integrations/sfdc"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+const testGroup = "members@example.org"
+
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Infof(format string, args ...interface{})  { l.t.Logf(format, args...) }
+func (l testLogger) Warnf(format string, args ...interface{})  { l.t.Logf(format, args...) }
+func (l testLogger) Errorf(format string, args ...interface{}) { l.t.Logf(format, args...) }
+
+func newGroupsReconciler(t *testing.T, members []*admin.Member) (*Reconciler, *googletest.Server) {
+	t.Helper()
+	srv := googletest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.SetMembers(testGroup, members)
+
+	gc, err := groups.NewClient(testGroup, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("failed to create groups client: %s", err)
+	}
+	return &Reconciler{
+		GroupsClient:    &gc,
+		GroupExceptions: []string{"treasurer@example.org"},
+		Logger:          testLogger{t},
+	}, srv
+}
+
+func groupRoles(srv *googletest.Server) map[string]string {
+	roles := make(map[string]string)
+	for _, m := range srv.Members(testGroup) {
+		roles[m.Email] = m.Role + "/" + m.DeliverySettings
+	}
+	return roles
+}
+
+func TestReconcileGroup(t *testing.T) {
+	r, srv := newGroupsReconciler(t, []*admin.Member{
+		{Email: "current@example.org"},
+		{Email: "janedoe@example.org"},
+		{Email: "handpicked@example.org", Role: groups.RoleManager},
+		{Email: "board@example.org"},
+		{Email: "lapsed@example.org"},
+		{Email: "treasurer@example.org", Role: groups.RoleManager},
+	})
+	contacts := []sfdc.Contact{
+		{ID: "1", GroupEmail: "current@example.org"},
+		// gmail ignores dots and case, so this is already in the group
+		{ID: "2", GroupEmail: "Jane.Doe@example.org"},
+		{ID: "3", GroupEmail: "handpicked@example.org"},
+		{ID: "4", GroupEmail: "board@example.org"},
+		{ID: "5", GroupEmail: "new@example.org", GroupDelivery: "digest"},
+	}
+	managers := map[string]bool{"4": true}
+	d := &diff{idx: newContactIndex(contacts)}
+
+	changes, err := r.reconcileGroup(contacts, managers, nil, d, false)
+	if err != nil {
+		t.Fatalf("reconcileGroup: %s", err)
+	}
+	if len(changes.Errored) > 0 {
+		t.Errorf("errored = %v", changes.Errored)
+	}
+
+	want := map[string]string{
+		"current@example.org":    "MEMBER/ALL_MAIL",
+		"janedoe@example.org":    "MEMBER/ALL_MAIL",
+		"handpicked@example.org": "MANAGER/ALL_MAIL",
+		"board@example.org":      "MANAGER/ALL_MAIL",
+		"new@example.org":        "MEMBER/DIGEST",
+		"treasurer@example.org":  "MANAGER/ALL_MAIL",
+	}
+	got := groupRoles(srv)
+	if len(got) != len(want) {
+		t.Errorf("group members = %v, want %v", got, want)
+	}
+	for email, role := range want {
+		if got[email] != role {
+			t.Errorf("%s = %q, want %q", email, got[email], role)
+		}
+	}
+
+	if len(changes.Additions) != 1 || changes.Additions[0] != "new@example.org" {
+		t.Errorf("additions = %v", changes.Additions)
+	}
+	if len(changes.Updates) != 1 {
+		t.Errorf("updates = %v", changes.Updates)
+	}
+	if len(changes.Deletions) != 1 || changes.Deletions[0] != "lapsed@example.org" {
+		t.Errorf("deletions = %v", changes.Deletions)
+	}
+}
+
+func TestReconcileGroupDryRun(t *testing.T) {
+	r, srv := newGroupsReconciler(t, []*admin.Member{
+		{Email: "lapsed@example.org"},
+		{Email: "treasurer@example.org"},
+	})
+	contacts := []sfdc.Contact{{ID: "1", GroupEmail: "new@example.org"}}
+	d := &diff{idx: newContactIndex(contacts)}
+
+	changes, err := r.reconcileGroup(contacts, nil, nil, d, true)
+	if err != nil {
+		t.Fatalf("reconcileGroup: %s", err)
+	}
+	if len(changes.Additions) != 1 || len(changes.Deletions) != 1 {
+		t.Errorf("changes = %+v, want one addition and one deletion", changes)
+	}
+	if len(d.actions) != 2 {
+		t.Errorf("diff has %d actions, want 2", len(d.actions))
+	}
+	if got := groupRoles(srv); len(got) != 2 || got["lapsed@example.org"] == "" {
+		t.Errorf("dry run changed the group: %v", got)
+	}
+}
+
+func TestReconcileGroupScoped(t *testing.T) {
+	r, srv := newGroupsReconciler(t, []*admin.Member{
+		{Email: "someone-else@example.org"},
+	})
+	contacts := []sfdc.Contact{{ID: "1", GroupEmail: "new@example.org"}}
+	d := &diff{idx: newContactIndex(contacts)}
+
+	changes, err := r.reconcileGroup(contacts, nil, newScope(contacts), d, false)
+	if err != nil {
+		t.Fatalf("reconcileGroup: %s", err)
+	}
+	// members outside the scope aren't looked at, let alone removed
+	if len(changes.Deletions) != 0 {
+		t.Errorf("deletions = %v, want none", changes.Deletions)
+	}
+	got := groupRoles(srv)
+	if got["someone-else@example.org"] == "" || got["new@example.org"] == "" {
+		t.Errorf("group members = %v", got)
+	}
+}
+
+func TestGroupSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		contact  sfdc.Contact
+		manager  bool
+		role     string
+		delivery string
+	}{
+		{"nothing set", sfdc.Contact{}, false, "", ""},
+		{"role and delivery", sfdc.Contact{GroupRole: " manager ", GroupDelivery: "Digest"}, false, groups.RoleManager, groups.DeliveryDigest},
+		{"picklist labels", sfdc.Contact{GroupRole: "Member", GroupDelivery: "No email"}, false, groups.RoleMember, groups.DeliveryNone},
+		{"all mail", sfdc.Contact{GroupDelivery: "All Mail"}, false, "", groups.DeliveryAllMail},
+		{"daily", sfdc.Contact{GroupDelivery: "daily"}, false, "", groups.DeliveryDaily},
+		{"unknown values are ignored", sfdc.Contact{GroupRole: "Admin", GroupDelivery: "weekly"}, false, "", ""},
+		{"board members are managers", sfdc.Contact{}, true, groups.RoleManager, ""},
+		{"board members are promoted", sfdc.Contact{GroupRole: "MEMBER"}, true, groups.RoleManager, ""},
+		{"board members keep owner", sfdc.Contact{GroupRole: "owner"}, true, groups.RoleOwner, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, delivery := groupSettings(tt.contact, tt.manager)
+			if role != tt.role || delivery != tt.delivery {
+				t.Errorf("groupSettings = %q, %q, want %q, %q", role, delivery, tt.role, tt.delivery)
+			}
+		})
+	}
+}
+
+func TestGroupMemberChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		member   admin.Member
+		want     groupMembership
+		role     string
+		delivery string
+	}{
+		// SFDC has no role, so one set by hand in the group stays
+		{"no role in sfdc", admin.Member{Role: groups.RoleManager, DeliverySettings: groups.DeliveryAllMail}, groupMembership{}, "", ""},
+		{"no delivery in sfdc", admin.Member{Role: groups.RoleMember, DeliverySettings: groups.DeliveryNone}, groupMembership{Role: groups.RoleMember}, "", ""},
+		{"already correct", admin.Member{Role: groups.RoleMember, DeliverySettings: groups.DeliveryDigest}, groupMembership{Role: groups.RoleMember, Delivery: groups.DeliveryDigest}, "", ""},
+		{"promote", admin.Member{Role: groups.RoleMember}, groupMembership{Role: groups.RoleManager}, groups.RoleManager, ""},
+		{"demote", admin.Member{Role: groups.RoleManager}, groupMembership{Role: groups.RoleMember}, groups.RoleMember, ""},
+		{"owners aren't demoted", admin.Member{Role: groups.RoleOwner}, groupMembership{Role: groups.RoleMember}, "", ""},
+		{"owners still get delivery", admin.Member{Role: groups.RoleOwner, DeliverySettings: groups.DeliveryAllMail}, groupMembership{Role: groups.RoleMember, Delivery: groups.DeliveryDaily}, "", groups.DeliveryDaily},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			member := tt.member
+			role, delivery := groupMemberChanges(&member, tt.want)
+			if role != tt.role || delivery != tt.delivery {
+				t.Errorf("groupMemberChanges = %q, %q, want %q, %q", role, delivery, tt.role, tt.delivery)
+			}
+		})
+	}
+}
@@ -3,35 +3,46 @@ package reconcile
 import (
 	"bytes"
 	_ "embed"
-	"html/template"
+	"fmt"
+	htmltemplate "html/template"
+	"text/template"
 	"time"
 )
 
 //go:embed template.txt
 var reportTemplateText string
 
+//go:embed template.html
+var reportTemplateHTML string
+
+//go:embed template.md
+var reportTemplateMarkdown string
+
 var reportTemplate = template.Must(template.New("report").Parse(reportTemplateText))
+var reportHTMLTemplate = htmltemplate.Must(htmltemplate.New("report").Parse(reportTemplateHTML))
+var reportMarkdownTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"md": escapeMarkdown}).Parse(reportTemplateMarkdown))
 
 type Report struct {
 	Date      time.Time          `json:"executionDate"`
 	Duration  time.Duration      `json:"executionDuration"`
 	User      string             `json:"user"`
-	CheckMeIn bool               `json:"checkmein"`
+	Scopes    []string           `json:"scopes"`
+	CheckMeIn Changes            `json:"checkmein"`
 	Discord   map[string]Changes `json:"discord"`
 	Groups    map[string]Changes `json:"groups"`
 }
 
 type Changes struct {
 	Additions []string `json:"add"`
+	Updates   []string `json:"update,omitempty"`
 	Deletions []string `json:"delete"`
 	Errored   []string `json:"errored,omitempty"`
+	// set when the target couldn't be reconciled at all
+	Error string `json:"error,omitempty"`
 }
 
-func (r Report) CheckMeInStatus() string {
-	if r.CheckMeIn {
-		return "SUCCESSFUL"
-	}
-	return "NOT SUCCESSFUL"
+func (c Changes) HasChanges() bool {
+	return len(c.Additions) > 0 || len(c.Updates) > 0 || len(c.Deletions) > 0 || len(c.Errored) > 0 || len(c.Error) > 0
 }
 
 func (r Report) RenderText() ([]byte, error) {
@@ -40,18 +51,66 @@ func (r Report) RenderText() ([]byte, error) {
 	return cache.Bytes(), err
 }
 
+func (r Report) RenderHTML() ([]byte, error) {
+	var cache bytes.Buffer
+	err := reportHTMLTemplate.Execute(&cache, r)
+	return cache.Bytes(), err
+}
+
+func (r Report) RenderMarkdown() ([]byte, error) {
+	var cache bytes.Buffer
+	err := reportMarkdownTemplate.Execute(&cache, r)
+	return cache.Bytes(), err
+}
+
+// Summary counts changes across every target, for logs
+func (r Report) Summary() string {
+	var add, update, del, errored int
+	count := func(c Changes) {
+		add += len(c.Additions)
+		update += len(c.Updates)
+		del += len(c.Deletions)
+		errored += len(c.Errored)
+	}
+	count(r.CheckMeIn)
+	for _, c := range r.Discord {
+		count(c)
+	}
+	for _, c := range r.Groups {
+		count(c)
+	}
+	return fmt.Sprintf("%d added, %d updated, %d removed, %d failed", add, update, del, errored)
+}
+
+// HasErrors reports whether any target failed, fully or for some members
+func (r Report) HasErrors() bool {
+	if len(r.CheckMeIn.Errored) > 0 || len(r.CheckMeIn.Error) > 0 {
+		return true
+	}
+	for _, d := range r.Discord {
+		if len(d.Errored) > 0 || len(d.Error) > 0 {
+			return true
+		}
+	}
+	for _, d := range r.Groups {
+		if len(d.Errored) > 0 || len(d.Error) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (r Report) HasChanges() bool {
-	// send report if CheckMeIn failed
-	if !r.CheckMeIn {
+	if r.CheckMeIn.HasChanges() {
 		return true
 	}
 	for _, d := range r.Discord {
-		if len(d.Additions) > 0 || len(d.Deletions) > 0 || len(d.Errored) > 0 {
+		if d.HasChanges() {
 			return true
 		}
 	}
 	for _, d := range r.Groups {
-		if len(d.Additions) > 0 || len(d.Deletions) > 0 || len(d.Errored) > 0 {
+		if d.HasChanges() {
 			return true
 		}
 	}
@@ -0,0 +1,35 @@
+package reconcile
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMarkdownEscapesErrors(t *testing.T) {
+	r := Report{
+		Date:      time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		CheckMeIn: Changes{Error: "failed to log in as *admin*: bad_password"},
+		Groups: map[string]Changes{
+			"members": {Error: "googleapi: Error 403: [rate_limit] exceeded"},
+		},
+	}
+	b, err := r.RenderMarkdown()
+	if err != nil {
+		t.Fatalf("RenderMarkdown: %s", err)
+	}
+	md := string(b)
+	for _, want := range []string{
+		"**Sync failed:** " + escapeMarkdown(r.CheckMeIn.Error) + "\n",
+		"**Sync failed:** " + escapeMarkdown(r.Groups["members"].Error) + "\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("report is missing %q:\n%s", want, md)
+		}
+	}
+	for _, raw := range []string{"*admin*", "bad_password", "[rate_limit]"} {
+		if strings.Contains(md, raw) {
+			t.Errorf("report has unescaped %q:\n%s", raw, md)
+		}
+	}
+}
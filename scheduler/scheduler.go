@@ -0,0 +1,191 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/theforgeinitiative/integrations/db"
+	"github.com/theforgeinitiative/integrations/reconcile"
+)
+
+const defaultLeaseTTL = 30 * time.Minute
+
+// Store is satisfied by db.Client
+type Store interface {
+	AcquireLease(name, holder string, tick time.Time, ttl time.Duration) (bool, error)
+	RenewLease(name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(name, holder string) error
+	RecordJobRun(run db.JobRun) error
+}
+
+// Job returns a short summary of what it did
+type Job func() (string, error)
+
+// Scheduler runs jobs on cron schedules. With a Store only one instance runs
+// each tick and records the run, without one every instance runs every job.
+type Scheduler struct {
+	Store  Store
+	Logger reconcile.Logger
+	// identifies this instance in leases and run records
+	Holder string
+	// the lease is renewed every third of this while a job runs, so it only
+	// limits how long a crashed instance blocks the others
+	LeaseTTL time.Duration
+
+	cron *cron.Cron
+}
+
+type entry struct {
+	name    string
+	job     Job
+	running int32
+}
+
+func NewScheduler(logger reconcile.Logger) *Scheduler {
+	return &Scheduler{
+		Logger:   logger,
+		Holder:   holderID(),
+		LeaseTTL: defaultLeaseTTL,
+		cron:     cron.New(),
+	}
+}
+
+// holderID is unique per process, Cloud Run instances can share a hostname
+func holderID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s", host, hex.EncodeToString(b))
+}
+
+// Add schedules job using a standard 5 field cron spec. Prefix the spec with
+// CRON_TZ=America/New_York to run in a different time zone than the server.
+func (s *Scheduler) Add(name, spec string, job Job) error {
+	e := &entry{name: name, job: job}
+	_, err := s.cron.AddFunc(spec, func() { s.run(e) })
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for %s: %w", spec, name, err)
+	}
+	return nil
+}
+
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop prevents new runs. The context is done once running jobs finish.
+func (s *Scheduler) Stop() context.Context {
+	return s.cron.Stop()
+}
+
+func (s *Scheduler) run(e *entry) {
+	// every instance fires within the same minute, which makes it the lease tick
+	tick := time.Now().Truncate(time.Minute)
+	// checked before the lease, which the previous run still holds and releases
+	if !atomic.CompareAndSwapInt32(&e.running, 0, 1) {
+		now := time.Now()
+		s.record(db.JobRun{
+			Job:        e.name,
+			Holder:     s.Holder,
+			Status:     db.JobSkipped,
+			StartedAt:  now,
+			FinishedAt: now,
+			Summary:    "previous run still going",
+		})
+		return
+	}
+	defer atomic.StoreInt32(&e.running, 0)
+
+	if s.Store != nil {
+		ok, err := s.Store.AcquireLease(e.name, s.Holder, tick, s.LeaseTTL)
+		if err != nil {
+			s.Logger.Errorf("Failed to acquire lease for scheduled %s: %s", e.name, err)
+			return
+		}
+		if !ok {
+			// every other instance ends up here each tick, so it isn't recorded
+			s.Logger.Infof("Skipping scheduled %s, another instance has it", e.name)
+			return
+		}
+	}
+
+	run := db.JobRun{Job: e.name, Holder: s.Holder, StartedAt: time.Now()}
+	stopHeartbeat := s.heartbeat(e.name)
+	summary, err := e.job()
+	stopHeartbeat()
+	run.FinishedAt = time.Now()
+	run.Summary = summary
+	run.Status = db.JobSucceeded
+	if err != nil {
+		run.Status = db.JobFailed
+		run.Error = err.Error()
+	}
+	s.record(run)
+
+	if s.Store != nil {
+		err = s.Store.ReleaseLease(e.name, s.Holder)
+		if err != nil {
+			s.Logger.Warnf("Failed to release lease for scheduled %s: %s", e.name, err)
+		}
+	}
+}
+
+// heartbeat keeps renewing the lease until the returned func is called, so
+// a run longer than LeaseTTL doesn't let another instance start it again
+func (s *Scheduler) heartbeat(name string) func() {
+	interval := s.LeaseTTL / 3
+	if s.Store == nil || interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ok, err := s.Store.RenewLease(name, s.Holder, s.LeaseTTL)
+				if err != nil {
+					s.Logger.Warnf("Failed to renew lease for scheduled %s: %s", name, err)
+					continue
+				}
+				if !ok {
+					s.Logger.Warnf("Lost lease for scheduled %s while it was running", name)
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (s *Scheduler) record(run db.JobRun) {
+	duration := run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond)
+	if run.Status == db.JobFailed {
+		s.Logger.Errorf("Scheduled %s failed after %s: %s (%s)", run.Job, duration, run.Error, run.Summary)
+	} else {
+		s.Logger.Infof("Scheduled %s %s after %s: %s", run.Job, run.Status, duration, run.Summary)
+	}
+	if s.Store == nil {
+		return
+	}
+	err := s.Store.RecordJobRun(run)
+	if err != nil {
+		s.Logger.Warnf("Failed to record scheduled %s run: %s", run.Job, err)
+	}
+}
@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/db"
+	"github.com/theforgeinitiative/integrations/reconcile"
+)
+
+type fakeStore struct {
+	mu       sync.Mutex
+	acquire  bool
+	acquires int
+	renewals int
+	released bool
+	runs     []db.JobRun
+}
+
+func (f *fakeStore) AcquireLease(name, holder string, tick time.Time, ttl time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acquires++
+	return f.acquire, nil
+}
+
+func (f *fakeStore) RenewLease(name, holder string, ttl time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.renewals++
+	return true, nil
+}
+
+func (f *fakeStore) ReleaseLease(name, holder string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.released = true
+	return nil
+}
+
+func (f *fakeStore) RecordJobRun(run db.JobRun) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.runs = append(f.runs, run)
+	return nil
+}
+
+func TestRunSkipsWithoutLease(t *testing.T) {
+	store := &fakeStore{acquire: false}
+	s := NewScheduler(reconcile.StdLogger{})
+	s.Store = store
+
+	ran := false
+	s.run(&entry{name: "reconcile", job: func() (string, error) {
+		ran = true
+		return "", nil
+	}})
+
+	if ran {
+		t.Error("job ran without the lease")
+	}
+	// every instance without the lease skips every tick, which isn't worth a record
+	if len(store.runs) != 0 {
+		t.Fatalf("expected no recorded runs, got %+v", store.runs)
+	}
+	if store.released {
+		t.Error("released a lease it didn't hold")
+	}
+}
+
+func TestRunSkipsOverlapBeforeLease(t *testing.T) {
+	store := &fakeStore{acquire: true}
+	s := NewScheduler(reconcile.StdLogger{})
+	s.Store = store
+	e := &entry{name: "reconcile", job: func() (string, error) {
+		t.Error("job ran while the previous run was still going")
+		return "", nil
+	}}
+	// the previous tick's run still has it
+	e.running = 1
+
+	s.run(e)
+
+	if store.acquires != 0 || store.released {
+		t.Errorf("lease was touched: %d acquires, released %t", store.acquires, store.released)
+	}
+	if len(store.runs) != 1 || store.runs[0].Status != db.JobSkipped {
+		t.Fatalf("expected one skipped run, got %+v", store.runs)
+	}
+	if e.running != 1 {
+		t.Error("skipping cleared the running flag of the previous run")
+	}
+}
+
+func TestRunClearsRunningWithoutLease(t *testing.T) {
+	s := NewScheduler(reconcile.StdLogger{})
+	s.Store = &fakeStore{acquire: false}
+	e := &entry{name: "reconcile", job: func() (string, error) { return "", nil }}
+
+	s.run(e)
+
+	if e.running != 0 {
+		t.Error("running flag left set after skipping for the lease")
+	}
+}
+
+func TestRunRenewsLeaseWhileRunning(t *testing.T) {
+	store := &fakeStore{acquire: true}
+	s := NewScheduler(reconcile.StdLogger{})
+	s.Store = store
+	s.LeaseTTL = 30 * time.Millisecond
+
+	s.run(&entry{name: "reconcile", job: func() (string, error) {
+		time.Sleep(100 * time.Millisecond)
+		return "done", nil
+	}})
+
+	if store.renewals == 0 {
+		t.Error("lease wasn't renewed during a run longer than the TTL")
+	}
+	if !store.released {
+		t.Error("lease wasn't released after the run")
+	}
+	if len(store.runs) != 1 || store.runs[0].Status != db.JobSucceeded {
+		t.Fatalf("expected one successful run, got %+v", store.runs)
+	}
+}
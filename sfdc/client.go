@@ -4,9 +4,11 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/simpleforce/simpleforce"
+	"github.com/theforgeinitiative/integrations/metrics"
 )
 
 // 100 day grace period
@@ -16,12 +18,25 @@ const DateFormat = "2006-01-02"
 
 const authSessionLength = 1 * time.Hour
 
+var ErrContactNotFound = errors.New("unable to find contact")
+
 type Client struct {
-	SFClient          *simpleforce.Client
+	SFClient *simpleforce.Client
+	// contact fields attendance is written to, default to DefaultVisitCountField
+	// and DefaultLastVisitField
+	VisitCountField   string
+	LastVisitField    string
 	clientSecret      string
 	lastAuthenticated time.Time
 }
 
+// Attendance fields on Contact. These are custom fields that have to be added
+// to the org before importing attendance, the names are only our convention.
+const (
+	DefaultVisitCountField = "TFI_Visit_Count__c"
+	DefaultLastVisitField  = "TFI_Last_Visit_Date__c"
+)
+
 func NewClient(url, clientID, clientSecret string) (Client, error) {
 	sfc := simpleforce.NewClient(url, clientID, simpleforce.DefaultAPIVersion)
 	err := sfc.LoginClientCredentials(clientSecret)
@@ -37,13 +52,27 @@ func NewClient(url, clientID, clientSecret string) (Client, error) {
 }
 
 func (c *Client) Authenticate() error {
+	done := metrics.Track("sfdc", "login")
 	err := c.SFClient.LoginClientCredentials(c.clientSecret)
+	done(err)
 	if err == nil {
 		c.lastAuthenticated = time.Now()
 	}
 	return err
 }
 
+// Ping runs a trivial query to check the session and connectivity
+func (c *Client) Ping() error {
+	if c.lastAuthenticated.Add(authSessionLength).Before(time.Now()) {
+		c.Authenticate()
+	}
+	_, err := c.query("SELECT Id FROM Contact LIMIT 1")
+	if err != nil {
+		return fmt.Errorf("failed to query sfdc: %w", err)
+	}
+	return nil
+}
+
 type Contact struct {
 	ID                string
 	AccountID         string
@@ -56,6 +85,8 @@ type Contact struct {
 	Email             string
 	GroupEmail        string
 	GroupEmailAlt     string
+	GroupRole         string
+	GroupDelivery     string
 	DiscordID         string
 	MembershipStatus  string
 }
@@ -64,6 +95,11 @@ func (c Contact) CurrentMember() bool {
 	return c.MembershipStatus == "Current" || c.MembershipStatus == "Grace Period"
 }
 
+// IsTestContact matches the contacts FindCurrentMembers leaves out
+func (c Contact) IsTestContact() bool {
+	return strings.Contains(strings.ToLower(c.FirstName+" "+c.LastName), "test")
+}
+
 func init() {
 	gob.Register(Contact{})
 }
@@ -74,10 +110,12 @@ func (c *Client) GetContact(id string) (Contact, error) {
 	}
 
 	// Get an SObject with given type and external ID
-	obj := c.SFClient.SObject("Contact").Get(id)
+	obj := trackSObject("get", func() *simpleforce.SObject {
+		return c.SFClient.SObject("Contact").Get(id)
+	})
 	if obj == nil {
 		// Object doesn't exist, handle the error
-		return Contact{}, fmt.Errorf("unable to find contact")
+		return Contact{}, ErrContactNotFound
 	}
 
 	return contactFromSObj(*obj), nil
@@ -85,13 +123,13 @@ func (c *Client) GetContact(id string) (Contact, error) {
 
 func (c *Client) FindContactByIDs(hid, pid string) (Contact, error) {
 	where := fmt.Sprintf(`TFI_Household_ID_ctct__c = '%s'
-        AND TFI_Personal_ID__c = '%s'`, hid, pid)
+        AND TFI_Personal_ID__c = '%s'`, escapeSOQL(hid), escapeSOQL(pid))
 	contacts, err := c.queryContacts(where)
 	if err != nil {
 		return Contact{}, err
 	}
 	if len(contacts) < 1 {
-		return Contact{}, fmt.Errorf("unable to find contact")
+		return Contact{}, ErrContactNotFound
 	}
 	return contacts[0], nil
 }
@@ -103,13 +141,13 @@ func (c *Client) FindCurrentMembers() ([]Contact, error) {
 }
 
 func (c *Client) GetContactByDiscordID(discordID string) (Contact, error) {
-	where := fmt.Sprintf("Discord_ID__c = '%s'", discordID)
+	where := fmt.Sprintf("Discord_ID__c = '%s'", escapeSOQL(discordID))
 	contacts, err := c.queryContacts(where)
 	if err != nil {
 		return Contact{}, err
 	}
 	if len(contacts) < 1 {
-		return Contact{}, fmt.Errorf("unable to find contact")
+		return Contact{}, ErrContactNotFound
 	}
 	return contacts[0], nil
 }
@@ -124,7 +162,7 @@ func (c *Client) GetCampaignMembershipStatus(contactID, campaignID string) (stri
 		CampaignId = '%s' AND ContactId = '%s'
 	`, campaignID, contactID)
 
-	result, err := c.SFClient.Query(q)
+	result, err := c.query(q)
 	if err != nil {
 		return "", fmt.Errorf("error running SOQL query: %s", err)
 	}
@@ -134,25 +172,55 @@ func (c *Client) GetCampaignMembershipStatus(contactID, campaignID string) (stri
 	return result.Records[0].StringField("Status"), nil
 }
 
+// CampaignContactIDs returns the IDs of every contact in a campaign, optionally
+// limited to those with the given status
+func (c *Client) CampaignContactIDs(campaignID, status string) (map[string]bool, error) {
+	if c.lastAuthenticated.Add(authSessionLength).Before(time.Now()) {
+		c.Authenticate()
+	}
+	where := fmt.Sprintf("CampaignId = '%s'", escapeSOQL(campaignID))
+	if len(status) > 0 {
+		where += fmt.Sprintf(" AND Status = '%s'", escapeSOQL(status))
+	}
+	q := fmt.Sprintf(`
+	SELECT
+		ContactId
+	FROM
+		CampaignMember
+	WHERE
+		%s
+	`, where)
+
+	result, err := c.query(q)
+	if err != nil {
+		return nil, fmt.Errorf("error running SOQL query: %s", err)
+	}
+	ids := make(map[string]bool, len(result.Records))
+	for _, r := range result.Records {
+		ids[r.StringField("ContactId")] = true
+	}
+	return ids, nil
+}
+
 func (c *Client) CreateCampaignMember(contactID, campaignID, status string) *simpleforce.SObject {
 	if c.lastAuthenticated.Add(authSessionLength).Before(time.Now()) {
 		c.Authenticate()
 	}
-	return c.SFClient.SObject("CampaignMember").
+	return trackSObject("create", c.SFClient.SObject("CampaignMember").
 		Set("ContactId", contactID).
 		Set("CampaignId", campaignID).
 		Set("Status", status).
-		Create()
+		Create)
 }
 
 func (c *Client) SetDiscordID(contactID, discordID string) error {
 	if c.lastAuthenticated.Add(authSessionLength).Before(time.Now()) {
 		c.Authenticate()
 	}
-	updateObj := c.SFClient.SObject("Contact").
+	updateObj := trackSObject("update", c.SFClient.SObject("Contact").
 		Set("Id", contactID).
 		Set("Discord_ID__c", discordID).
-		Update()
+		Update)
 
 	if updateObj == nil {
 		return errors.New("failed to update contact")
@@ -161,6 +229,190 @@ func (c *Client) SetDiscordID(contactID, discordID string) error {
 	return nil
 }
 
+// FindContactsByBarcodes looks up contacts regardless of membership status
+func (c *Client) FindContactsByBarcodes(barcodes []string) ([]Contact, error) {
+	if len(barcodes) == 0 {
+		return nil, nil
+	}
+	return c.queryContactsIn(barcodes, func(list string) string {
+		return fmt.Sprintf("TFI_Barcode_for_Button__c IN (%s)", list)
+	})
+}
+
+// FindContactsByIDs looks up contacts regardless of membership status
+func (c *Client) FindContactsByIDs(ids []string) ([]Contact, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return c.queryContactsIn(ids, func(list string) string {
+		return fmt.Sprintf("Id IN (%s)", list)
+	})
+}
+
+// FindContactsByAccountIDs returns everyone in the given households
+func (c *Client) FindContactsByAccountIDs(ids []string) ([]Contact, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return c.queryContactsIn(ids, func(list string) string {
+		return fmt.Sprintf("AccountId IN (%s)", list)
+	})
+}
+
+// FindContactsByEmail matches the contact email and both Google Group addresses
+func (c *Client) FindContactsByEmail(email string) ([]Contact, error) {
+	e := escapeSOQL(email)
+	where := fmt.Sprintf("Email = '%s' OR Google_group__c = '%s' OR Google_group_email_2ndary__c = '%s'", e, e, e)
+	return c.queryContacts(where)
+}
+
+// FindContactsByGroupEmails matches either Google Group address. SOQL
+// compares text case-insensitively, but it can't ignore dots the way Gmail
+// does, so an address stored with different dots won't be found.
+func (c *Client) FindContactsByGroupEmails(emails []string) ([]Contact, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	lower := make([]string, len(emails))
+	for i, e := range emails {
+		lower[i] = strings.ToLower(e)
+	}
+	return c.queryContactsIn(lower, func(list string) string {
+		return fmt.Sprintf("Google_group__c IN (%s) OR Google_group_email_2ndary__c IN (%s)", list, list)
+	})
+}
+
+func (c *Client) FindContactsByDiscordIDs(ids []string) ([]Contact, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return c.queryContactsIn(ids, func(list string) string {
+		return fmt.Sprintf("Discord_ID__c IN (%s)", list)
+	})
+}
+
+const (
+	GroupEmailField          = "Google_group__c"
+	GroupEmailSecondaryField = "Google_group_email_2ndary__c"
+)
+
+// SetGroupEmail sets one of the contact's Google Group addresses, see GroupEmailField and GroupEmailSecondaryField
+func (c *Client) SetGroupEmail(contactID, field, email string) error {
+	if c.lastAuthenticated.Add(authSessionLength).Before(time.Now()) {
+		c.Authenticate()
+	}
+	updateObj := trackSObject("update", c.SFClient.SObject("Contact").
+		Set("Id", contactID).
+		Set(field, email).
+		Update)
+
+	if updateObj == nil {
+		return errors.New("failed to update contact")
+	}
+
+	return nil
+}
+
+// SetVisitStats records attendance pulled from CheckMeIn on the contact
+func (c *Client) SetVisitStats(contactID string, visits int, lastVisit time.Time) error {
+	if c.lastAuthenticated.Add(authSessionLength).Before(time.Now()) {
+		c.Authenticate()
+	}
+	countField, lastField := c.VisitCountField, c.LastVisitField
+	if len(countField) == 0 {
+		countField = DefaultVisitCountField
+	}
+	if len(lastField) == 0 {
+		lastField = DefaultLastVisitField
+	}
+	obj := c.SFClient.SObject("Contact").
+		Set("Id", contactID).
+		Set(countField, visits)
+	if !lastVisit.IsZero() {
+		obj.Set(lastField, lastVisit.Format(DateFormat))
+	}
+	if trackSObject("update", obj.Update) == nil {
+		return errors.New("failed to update contact")
+	}
+
+	return nil
+}
+
+func escapeSOQL(s string) string {
+	return soqlEscaper.Replace(s)
+}
+
+var soqlEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// soqlChunkSize keeps IN lists well under the SOQL query length limit
+const soqlChunkSize = 200
+
+// queryContactsIn runs one query per chunk of values, where builds the WHERE
+// clause from a quoted list. A contact matching in more than one chunk is
+// only returned once.
+func (c *Client) queryContactsIn(values []string, where func(list string) string) ([]Contact, error) {
+	var contacts []Contact
+	seen := make(map[string]bool)
+	for _, chunk := range chunkStrings(values, soqlChunkSize) {
+		found, err := c.queryContacts(where(soqlList(chunk)))
+		if err != nil {
+			return nil, err
+		}
+		for _, contact := range found {
+			if seen[contact.ID] {
+				continue
+			}
+			seen[contact.ID] = true
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts, nil
+}
+
+func chunkStrings(values []string, size int) [][]string {
+	var chunks [][]string
+	for len(values) > size {
+		chunks = append(chunks, values[:size])
+		values = values[size:]
+	}
+	if len(values) > 0 {
+		chunks = append(chunks, values)
+	}
+	return chunks
+}
+
+// soqlList quotes values for an IN clause
+func soqlList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + escapeSOQL(v) + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func (c *Client) query(q string) (*simpleforce.QueryResult, error) {
+	done := metrics.Track("sfdc", "query")
+	result, err := c.SFClient.Query(q)
+	done(err)
+	return result, err
+}
+
+var errSObjectFailed = errors.New("sobject call failed")
+
+// trackSObject records a get, create or update. simpleforce returns nil when they fail.
+func trackSObject(operation string, call func() *simpleforce.SObject) *simpleforce.SObject {
+	done := metrics.Track("sfdc", operation)
+	obj := call()
+	if obj == nil {
+		done(errSObjectFailed)
+	} else {
+		done(nil)
+	}
+	return obj
+}
+
+// queryContacts selects every field contactFromSObj reads. They all have to
+// exist in the org, see the README.
 func (c *Client) queryContacts(where string) ([]Contact, error) {
 	if c.lastAuthenticated.Add(authSessionLength).Before(time.Now()) {
 		c.Authenticate()
@@ -179,6 +431,8 @@ func (c *Client) queryContacts(where string) ([]Contact, error) {
 		Email,
 		Google_group__c,
 		Google_group_email_2ndary__c,
+		Google_group_role__c,
+		Google_group_delivery__c,
 		Discord_ID__c,
 		Account.npsp__Membership_Status__c
     FROM
@@ -186,7 +440,7 @@ func (c *Client) queryContacts(where string) ([]Contact, error) {
 	WHERE
 		%s
 	`, where)
-	result, err := c.SFClient.Query(q)
+	result, err := c.query(q)
 	if err != nil {
 		return nil, fmt.Errorf("error running SOQL query: %s", err)
 	}
@@ -209,7 +463,10 @@ func contactFromSObj(obj simpleforce.SObject) Contact {
 		Email:             obj.StringField("Email"),
 		GroupEmail:        obj.StringField("Google_group__c"),
 		GroupEmailAlt:     obj.StringField("Google_group_email_2ndary__c"),
+		GroupRole:         obj.StringField("Google_group_role__c"),
+		GroupDelivery:     obj.StringField("Google_group_delivery__c"),
 		DiscordID:         obj.StringField("Discord_ID__c"),
+		AccountID:         obj.SObjectField("Account", "Account").StringField("Id"),
 		MembershipStatus:  obj.SObjectField("Account", "Account").StringField("npsp__Membership_Status__c"),
 	}
 }
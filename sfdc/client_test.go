@@ -0,0 +1,27 @@
+package sfdc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestChunkStrings(t *testing.T) {
+	values := make([]string, 2*soqlChunkSize+1)
+	for i := range values {
+		values[i] = fmt.Sprint(i)
+	}
+	chunks := chunkStrings(values, soqlChunkSize)
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+	if len(chunks[0]) != soqlChunkSize || len(chunks[1]) != soqlChunkSize || len(chunks[2]) != 1 {
+		t.Errorf("unexpected chunk sizes %d, %d, %d", len(chunks[0]), len(chunks[1]), len(chunks[2]))
+	}
+	if chunks[2][0] != values[len(values)-1] {
+		t.Errorf("last chunk has %q, expected %q", chunks[2][0], values[len(values)-1])
+	}
+
+	if chunks := chunkStrings(nil, soqlChunkSize); len(chunks) != 0 {
+		t.Errorf("expected no chunks for no values, got %d", len(chunks))
+	}
+}
@@ -0,0 +1,82 @@
+package sfdc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// OutboundAck tells Salesforce an outbound message was handled. Anything else
+// and it keeps retrying for up to a day.
+const OutboundAck = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/">
+<soapenv:Body>
+<notificationsResponse xmlns="http://soap.sforce.com/2005/09/outbound">
+<Ack>true</Ack>
+</notificationsResponse>
+</soapenv:Body>
+</soapenv:Envelope>`
+
+// OutboundMessage is the body of a workflow or flow outbound message
+type OutboundMessage struct {
+	OrganizationID string
+	ActionID       string
+	// IDs of the changed records, by sObject type
+	ContactIDs []string
+	AccountIDs []string
+}
+
+type outboundEnvelope struct {
+	Body struct {
+		Notifications struct {
+			OrganizationID string `xml:"OrganizationId"`
+			ActionID       string `xml:"ActionId"`
+			Notification   []struct {
+				SObject struct {
+					Type string `xml:"http://www.w3.org/2001/XMLSchema-instance type,attr"`
+					ID   string `xml:"Id"`
+				} `xml:"sObject"`
+			} `xml:"Notification"`
+		} `xml:"notifications"`
+	} `xml:"Body"`
+}
+
+// ParseOutboundMessage reads Contact and Account notifications from a SOAP
+// outbound message. Other sObject types are an error so misconfigured
+// messages show up in Salesforce's delivery status.
+func ParseOutboundMessage(r io.Reader) (OutboundMessage, error) {
+	var env outboundEnvelope
+	err := xml.NewDecoder(r).Decode(&env)
+	if err != nil {
+		return OutboundMessage{}, fmt.Errorf("failed to decode outbound message: %w", err)
+	}
+	n := env.Body.Notifications
+	msg := OutboundMessage{
+		OrganizationID: n.OrganizationID,
+		ActionID:       n.ActionID,
+	}
+	if len(n.Notification) == 0 {
+		return OutboundMessage{}, fmt.Errorf("outbound message has no notifications")
+	}
+	for _, notification := range n.Notification {
+		obj := notification.SObject
+		if len(obj.ID) == 0 {
+			return OutboundMessage{}, fmt.Errorf("outbound notification is missing an Id")
+		}
+		// the type is namespaced, like sf:Contact
+		_, typ, ok := strings.Cut(obj.Type, ":")
+		if !ok {
+			typ = obj.Type
+		}
+		switch typ {
+		case "Contact":
+			msg.ContactIDs = append(msg.ContactIDs, obj.ID)
+		case "Account":
+			msg.AccountIDs = append(msg.AccountIDs, obj.ID)
+		default:
+			return OutboundMessage{}, fmt.Errorf("unsupported sObject type in outbound message: %s", obj.Type)
+		}
+	}
+	return msg, nil
+}
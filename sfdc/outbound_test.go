@@ -0,0 +1,62 @@
+package sfdc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testOutboundMessage = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+ <soapenv:Body>
+  <notifications xmlns="http://soap.sforce.com/2005/09/outbound">
+   <OrganizationId>00D000000000001</OrganizationId>
+   <ActionId>04k000000000001</ActionId>
+   <Notification>
+    <Id>04l000000000001</Id>
+    <sObject xsi:type="sf:Contact" xmlns:sf="urn:sobject.enterprise.soap.sforce.com">
+     <sf:Id>003000000000001</sf:Id>
+    </sObject>
+   </Notification>
+   <Notification>
+    <Id>04l000000000002</Id>
+    <sObject xsi:type="sf:Account" xmlns:sf="urn:sobject.enterprise.soap.sforce.com">
+     <sf:Id>001000000000001</sf:Id>
+    </sObject>
+   </Notification>
+  </notifications>
+ </soapenv:Body>
+</soapenv:Envelope>`
+
+func TestParseOutboundMessage(t *testing.T) {
+	msg, err := ParseOutboundMessage(strings.NewReader(testOutboundMessage))
+	if err != nil {
+		t.Fatalf("ParseOutboundMessage: %s", err)
+	}
+	want := OutboundMessage{
+		OrganizationID: "00D000000000001",
+		ActionID:       "04k000000000001",
+		ContactIDs:     []string{"003000000000001"},
+		AccountIDs:     []string{"001000000000001"},
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("ParseOutboundMessage = %+v, want %+v", msg, want)
+	}
+}
+
+func TestParseOutboundMessageErrors(t *testing.T) {
+	tests := map[string]string{
+		"not xml":          "definitely not soap",
+		"no notifications": strings.Replace(testOutboundMessage, "Notification>", "Other>", -1),
+		"missing id":       strings.Replace(testOutboundMessage, "<sf:Id>003000000000001</sf:Id>", "", 1),
+		"other sobject":    strings.Replace(testOutboundMessage, "sf:Account", "sf:Opportunity", 1),
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseOutboundMessage(strings.NewReader(body))
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
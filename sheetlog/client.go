@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/theforgeinitiative/integrations/metrics"
 	"github.com/theforgeinitiative/integrations/sfdc"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
@@ -18,8 +19,11 @@ type Client struct {
 
 const logDateFormat = "2006-01-02 03:04:05PM"
 
-func NewClient(id, name string) (Client, error) {
-	sheetsSvc, err := sheets.NewService(context.TODO(), option.WithScopes("https://www.googleapis.com/auth/spreadsheets"))
+// NewClient uses application default credentials against the production Sheets
+// API. Options are applied on top, e.g. option.WithEndpoint for a fake server.
+func NewClient(id, name string, opts ...option.ClientOption) (Client, error) {
+	opts = append([]option.ClientOption{option.WithScopes("https://www.googleapis.com/auth/spreadsheets")}, opts...)
+	sheetsSvc, err := sheets.NewService(context.TODO(), opts...)
 	if err != nil {
 		return Client{}, fmt.Errorf("failed to create admin service: %w", err)
 	}
@@ -28,11 +32,21 @@ func NewClient(id, name string) (Client, error) {
 }
 
 func (c *Client) StorageLog(contact sfdc.Contact, lock string) error {
+	return c.appendRow(time.Now().Format(logDateFormat), lock, contact.FirstName, contact.LastName, contact.ID)
+}
+
+func (c *Client) DoorLog(contact sfdc.Contact, door string) error {
+	return c.appendRow(time.Now().Format(logDateFormat), door, contact.FirstName, contact.LastName, contact.ID)
+}
+
+func (c *Client) appendRow(values ...interface{}) error {
 	row := &sheets.ValueRange{
-		Values: [][]interface{}{{time.Now().Format(logDateFormat), lock, contact.FirstName, contact.LastName, contact.ID}},
+		Values: [][]interface{}{values},
 	}
 
+	done := metrics.Track("sheets", "append")
 	resp, err := c.svc.Spreadsheets.Values.Append(c.SheetID, c.SpreadsheetName, row).ValueInputOption("USER_ENTERED").InsertDataOption("INSERT_ROWS").Do()
+	done(err)
 	if err != nil {
 		return fmt.Errorf("failed to append to sheet: %w", err)
 	}
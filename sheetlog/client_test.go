@@ -0,0 +1,100 @@
+package sheetlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theforgeinitiative/integrations/googletest"
+	"github.com/theforgeinitiative/integrations/sfdc"
+)
+
+const testSheetID = "sheet-1"
+
+var testContact = sfdc.Contact{ID: "003000000000001", FirstName: "Jane", LastName: "Doe"}
+
+func newTestClient(t *testing.T, name string) (Client, *googletest.Server) {
+	t.Helper()
+	srv := googletest.NewServer()
+	t.Cleanup(srv.Close)
+	c, err := NewClient(testSheetID, name, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("failed to create sheetlog client: %s", err)
+	}
+	return c, srv
+}
+
+func TestLogs(t *testing.T) {
+	c, srv := newTestClient(t, "Log")
+
+	before := time.Now().Truncate(time.Second)
+	err := c.StorageLog(testContact, "12")
+	if err != nil {
+		t.Fatalf("StorageLog: %s", err)
+	}
+	err = c.DoorLog(testContact, "front")
+	if err != nil {
+		t.Fatalf("DoorLog: %s", err)
+	}
+
+	rows := srv.Rows(testSheetID, "Log")
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2: %v", len(rows), rows)
+	}
+	for i, what := range []string{"12", "front"} {
+		row := rows[i]
+		if len(row) != 5 || row[1] != what || row[2] != "Jane" || row[3] != "Doe" || row[4] != testContact.ID {
+			t.Errorf("row %d = %v", i, row)
+			continue
+		}
+		logged, err := time.ParseInLocation(logDateFormat, row[0].(string), time.Local)
+		if err != nil {
+			t.Errorf("row %d has a bad date %q: %s", i, row[0], err)
+		} else if logged.Before(before) || logged.After(time.Now()) {
+			t.Errorf("row %d logged at %s, want about now", i, logged)
+		}
+	}
+
+	// read it back the way someone looking at the sheet would
+	got, err := c.svc.Spreadsheets.Values.Get(testSheetID, "Log").Do()
+	if err != nil {
+		t.Fatalf("values.get: %s", err)
+	}
+	if len(got.Values) != 2 || got.Values[1][1] != "front" {
+		t.Errorf("values = %v", got.Values)
+	}
+}
+
+func TestLogsAreSeparate(t *testing.T) {
+	storage, srv := newTestClient(t, "Storage")
+	doors, err := NewClient(testSheetID, "Doors", srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range []error{
+		storage.StorageLog(testContact, "12"),
+		doors.DoorLog(testContact, "front"),
+		doors.DoorLog(testContact, "back"),
+	} {
+		if err != nil {
+			t.Fatalf("failed to log: %s", err)
+		}
+	}
+
+	if rows := srv.Rows(testSheetID, "Storage"); len(rows) != 1 {
+		t.Errorf("storage rows = %v", rows)
+	}
+	if rows := srv.Rows(testSheetID, "Doors"); len(rows) != 2 || rows[1][1] != "back" {
+		t.Errorf("door rows = %v", rows)
+	}
+}
+
+func TestLogFails(t *testing.T) {
+	c, srv := newTestClient(t, "Log")
+	srv.Close()
+
+	err := c.DoorLog(testContact, "front")
+	if err == nil {
+		t.Error("expected an error when sheets is unreachable")
+	}
+}